@@ -0,0 +1,195 @@
+// Code generated by capgen from capsule/capsule.go. DO NOT EDIT.
+
+package main_test
+
+import (
+	"context"
+)
+
+// StringCapsule stores its elements in a ring buffer that grows and shrinks
+// with the number of elements.
+type StringCapsule struct {
+	s    []string
+	head int // index of the next element to Get
+	n    int // number of elements
+}
+
+func NewStringCapsule() *StringCapsule {
+	return &StringCapsule{}
+}
+
+func (c *StringCapsule) Put(val string) {
+	if c.n == len(c.s) {
+		c.resize(2 * c.n)
+	}
+	c.s[(c.head+c.n)%len(c.s)] = val
+	c.n++
+}
+
+func (c *StringCapsule) Get() string {
+	if c.n == 0 {
+		panic("capsule: Get from empty capsule")
+	}
+	var zero string
+	r := c.s[c.head]
+	c.s[c.head] = zero // do not keep the element alive
+	c.head = (c.head + 1) % len(c.s)
+	c.n--
+	if c.n <= len(c.s)/4 {
+		c.resize(len(c.s) / 2)
+	}
+	return r
+}
+
+func (c *StringCapsule) Len() int {
+	return c.n
+}
+
+// ToChan returns a channel that a goroutine feeds with the elements of the
+// capsule, in the order of Get. The goroutine closes the channel and exits
+// when the capsule is empty or ctx is done, whatever comes first. It only
+// removes an element once the element has been received, so after a
+// cancellation, the capsule still holds the elements that nobody received.
+// The capsule must not be used by anyone else until the channel is closed.
+func (c *StringCapsule) ToChan(ctx context.Context) <-chan string {
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		for c.n > 0 {
+			select {
+			case ch <- c.s[c.head]:
+				c.Get()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// FromChan puts the elements received from ch into the capsule until ch is
+// closed, and returns nil then. If ctx is done first, FromChan returns
+// ctx.Err(). It does not start any goroutines.
+func (c *StringCapsule) FromChan(ctx context.Context, ch <-chan string) error {
+	for {
+		select {
+		case val, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			c.Put(val)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// resize moves the elements into a new ring buffer of the given size, which
+// must be at least c.n. The size never goes below 8.
+func (c *StringCapsule) resize(size int) {
+	if size < 8 {
+		size = 8
+	}
+	if size == len(c.s) {
+		return
+	}
+	s := make([]string, size)
+	k := copy(s, c.s[c.head:min(c.head+c.n, len(c.s))])
+	copy(s[k:], c.s[:c.n-k])
+	c.s = s
+	c.head = 0
+}
+
+// BigElemCapsule stores its elements in a ring buffer that grows and shrinks
+// with the number of elements.
+type BigElemCapsule struct {
+	s    []bigElem
+	head int // index of the next element to Get
+	n    int // number of elements
+}
+
+func NewBigElemCapsule() *BigElemCapsule {
+	return &BigElemCapsule{}
+}
+
+func (c *BigElemCapsule) Put(val bigElem) {
+	if c.n == len(c.s) {
+		c.resize(2 * c.n)
+	}
+	c.s[(c.head+c.n)%len(c.s)] = val
+	c.n++
+}
+
+func (c *BigElemCapsule) Get() bigElem {
+	if c.n == 0 {
+		panic("capsule: Get from empty capsule")
+	}
+	var zero bigElem
+	r := c.s[c.head]
+	c.s[c.head] = zero // do not keep the element alive
+	c.head = (c.head + 1) % len(c.s)
+	c.n--
+	if c.n <= len(c.s)/4 {
+		c.resize(len(c.s) / 2)
+	}
+	return r
+}
+
+func (c *BigElemCapsule) Len() int {
+	return c.n
+}
+
+// ToChan returns a channel that a goroutine feeds with the elements of the
+// capsule, in the order of Get. The goroutine closes the channel and exits
+// when the capsule is empty or ctx is done, whatever comes first. It only
+// removes an element once the element has been received, so after a
+// cancellation, the capsule still holds the elements that nobody received.
+// The capsule must not be used by anyone else until the channel is closed.
+func (c *BigElemCapsule) ToChan(ctx context.Context) <-chan bigElem {
+	ch := make(chan bigElem)
+	go func() {
+		defer close(ch)
+		for c.n > 0 {
+			select {
+			case ch <- c.s[c.head]:
+				c.Get()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// FromChan puts the elements received from ch into the capsule until ch is
+// closed, and returns nil then. If ctx is done first, FromChan returns
+// ctx.Err(). It does not start any goroutines.
+func (c *BigElemCapsule) FromChan(ctx context.Context, ch <-chan bigElem) error {
+	for {
+		select {
+		case val, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			c.Put(val)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// resize moves the elements into a new ring buffer of the given size, which
+// must be at least c.n. The size never goes below 8.
+func (c *BigElemCapsule) resize(size int) {
+	if size < 8 {
+		size = 8
+	}
+	if size == len(c.s) {
+		return
+	}
+	s := make([]bigElem, size)
+	k := copy(s, c.s[c.head:min(c.head+c.n, len(c.s))])
+	copy(s[k:], c.s[:c.n-k])
+	c.s = s
+	c.head = 0
+}
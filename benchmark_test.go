@@ -0,0 +1,591 @@
+package main_test
+
+//go:generate go run ./cmd/capgen -in=capsule/capsule.go -only=ItemCapsule,NewItemCapsule -out=benchcapsules_test.go -pkg=main_test "Item=string,bigElem"
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+
+	generics "github.com/appliedgo/generics"
+	"github.com/appliedgo/generics/capsule"
+)
+
+// bigElem is the large struct element type of the benchmarks.
+type bigElem struct {
+	ID      int64
+	Name    string
+	Payload [14]int64
+}
+
+// A benchTable compares several techniques (the columns) across a number of
+// scenarios (the rows). Each Benchmark function runs one table, with a
+// sub-benchmark named row/column per cell. cmd/benchreport turns the output
+// of `go test -bench` back into tables:
+//
+//	go test -run '^$' -bench . | go run ./cmd/benchreport
+type benchTable struct {
+	columns []string
+	rows    []benchRow
+}
+
+// A benchRow holds one benchmark function per column. A nil function means
+// that the technique does not apply to the scenario.
+type benchRow struct {
+	name  string
+	bench []func(b *testing.B)
+}
+
+func BenchmarkTechniques(b *testing.B) { runTable(b, techniqueTable()) }
+func BenchmarkChurn(b *testing.B)      { runTable(b, churnTable()) }
+func BenchmarkIndex(b *testing.B)      { runTable(b, indexTable()) }
+func BenchmarkHandoff(b *testing.B)    { runTable(b, handoffTable()) }
+func BenchmarkSPSC(b *testing.B)       { runTable(b, spscTable()) }
+func BenchmarkPersist(b *testing.B)    { runTable(b, persistTable()) }
+
+// runTable runs the cells of t as sub-benchmarks.
+func runTable(b *testing.B, t benchTable) {
+	for _, row := range t.rows {
+		for i, f := range row.bench {
+			if f != nil {
+				b.Run(row.name+"/"+t.columns[i], f)
+			}
+		}
+	}
+}
+
+var benchSizes = []int{10, 1000, 100000}
+
+// techniqueTable compares the techniques from the article: type assertions
+// (Container), reflection (Cabinet), code generation (generated capsules),
+// and type parameters (capsule.Capsule[T]).
+//
+// The "burst" workload fills the queue to the given size, then drains it.
+// The "steady" workload keeps the queue at the given size and does one Put
+// and one Get per operation. Either way, one op is one element that passes
+// through the queue.
+func techniqueTable() benchTable {
+	t := benchTable{
+		columns: []string{"Container", "Cabinet", "generated", "Capsule[T]"},
+	}
+	ints := []uint32{1, 2, 3, 5, 8, 13, 21, 34}
+	strs := []string{"who", "needs", "generics", "use", "interfaces", "reflection", "or", "genny"}
+	bigs := make([]bigElem, 8)
+	for i := range bigs {
+		bigs[i] = bigElem{ID: int64(i), Name: strs[i]}
+	}
+	for _, burst := range []bool{true, false} {
+		workload := "steady"
+		if burst {
+			workload = "burst"
+		}
+		for _, n := range benchSizes {
+			t.rows = append(t.rows,
+				benchRow{fmt.Sprintf("%s/uint32/%d", workload, n), []func(*testing.B){
+					benchContainer(ints, n, burst),
+					benchCabinet(ints, n, burst),
+					benchQueue(func() queue[uint32] { return capsule.NewUint32Capsule() }, ints, n, burst),
+					benchQueue(func() queue[uint32] { return capsule.New[uint32]() }, ints, n, burst),
+				}},
+				benchRow{fmt.Sprintf("%s/string/%d", workload, n), []func(*testing.B){
+					benchContainer(strs, n, burst),
+					benchCabinet(strs, n, burst),
+					benchQueue(func() queue[string] { return NewStringCapsule() }, strs, n, burst),
+					benchQueue(func() queue[string] { return capsule.New[string]() }, strs, n, burst),
+				}},
+				benchRow{fmt.Sprintf("%s/bigElem/%d", workload, n), []func(*testing.B){
+					benchContainer(bigs, n, burst),
+					benchCabinet(bigs, n, burst),
+					benchQueue(func() queue[bigElem] { return NewBigElemCapsule() }, bigs, n, burst),
+					benchQueue(func() queue[bigElem] { return capsule.New[bigElem]() }, bigs, n, burst),
+				}},
+			)
+		}
+	}
+	return t
+}
+
+// queue is the API that the generated capsules and Capsule[T] share.
+type queue[T any] interface {
+	Put(T)
+	Get() T
+}
+
+// sink keeps the compiler from optimizing away the retrieved elements.
+var sink interface{}
+
+// workload runs the burst or steady workload through put and get. All
+// techniques are called through func values, so the call overhead is the
+// same for all of them.
+func workload[T any](b *testing.B, put func(T), get func() T, vals []T, n int, burst bool) {
+	if !burst {
+		for i := 0; i < n; i++ {
+			put(vals[i%len(vals)])
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	var last T
+	size := 0
+	for i := 0; i < b.N; i++ {
+		put(vals[i%len(vals)])
+		if !burst {
+			last = get()
+			continue
+		}
+		size++
+		if size < n && i < b.N-1 {
+			continue
+		}
+		for ; size > 0; size-- {
+			last = get()
+		}
+	}
+	sink = last
+}
+
+func benchContainer[T any](vals []T, n int, burst bool) func(b *testing.B) {
+	return func(b *testing.B) {
+		c := &generics.Container{}
+		workload(b, func(v T) { c.Put(v) }, func() T { return c.Get().(T) }, vals, n, burst)
+	}
+}
+
+func benchCabinet[T any](vals []T, n int, burst bool) func(b *testing.B) {
+	return func(b *testing.B) {
+		c := generics.NewCabinet(reflect.TypeOf(vals[0]))
+		get := func() T {
+			var v T
+			if err := c.Get(&v); err != nil {
+				b.Fatal(err)
+			}
+			return v
+		}
+		workload(b, func(v T) { c.Put(v) }, get, vals, n, burst)
+	}
+}
+
+// benchQueue runs the workload on one of the statically typed queues.
+func benchQueue[T any](newQueue func() queue[T], vals []T, n int, burst bool) func(b *testing.B) {
+	return func(b *testing.B) {
+		q := newQueue()
+		workload(b, q.Put, q.Get, vals, n, burst)
+	}
+}
+
+// sliceQueue is a queue that dequeues by reslicing, as all queues of this
+// package did before they switched to ring buffers. It serves as the baseline
+// for the churn benchmarks.
+type sliceQueue[T any] struct {
+	s []T
+}
+
+func (q *sliceQueue[T]) Put(val T) {
+	q.s = append(q.s, val)
+}
+
+func (q *sliceQueue[T]) Get() T {
+	r := q.s[0]
+	q.s = q.s[1:]
+	return r
+}
+
+// churnTable shows how much memory a queue retains under churn. Each
+// "spike" fills the queue from its base level of 10 elements up to the given
+// size and drains it back to the base level. After the run, the benchmark
+// measures how much heap memory the queue (still holding the base level)
+// keeps alive, including the elements that pointers in the queue refer to.
+func churnTable() benchTable {
+	t := benchTable{
+		columns: []string{"slice", "Container", "generated", "Capsule[T]"},
+	}
+	ints := []uint32{1, 2, 3, 5, 8, 13, 21, 34}
+	ptrs := make([]*bigElem, 8)
+	for _, n := range []int{1000, 100000} {
+		t.rows = append(t.rows,
+			benchRow{fmt.Sprintf("spike/uint32/%d", n), []func(*testing.B){
+				benchChurn(func() queue[uint32] { return &sliceQueue[uint32]{} }, ints, n),
+				benchChurn(func() queue[uint32] { return containerQueue[uint32]{&generics.Container{}} }, ints, n),
+				benchChurn(func() queue[uint32] { return capsule.NewUint32Capsule() }, ints, n),
+				benchChurn(func() queue[uint32] { return capsule.New[uint32]() }, ints, n),
+			}},
+			benchRow{fmt.Sprintf("spike/*bigElem/%d", n), []func(*testing.B){
+				benchChurn(func() queue[*bigElem] { return &sliceQueue[*bigElem]{} }, ptrs, n),
+				benchChurn(func() queue[*bigElem] { return containerQueue[*bigElem]{&generics.Container{}} }, ptrs, n),
+				nil,
+				benchChurn(func() queue[*bigElem] { return capsule.New[*bigElem]() }, ptrs, n),
+			}},
+		)
+	}
+	return t
+}
+
+// containerQueue adapts a Container to the queue interface.
+type containerQueue[T any] struct {
+	c *generics.Container
+}
+
+func (q containerQueue[T]) Put(val T) { q.c.Put(val) }
+func (q containerQueue[T]) Get() T    { return q.c.Get().(T) }
+
+// benchChurn runs spikes through a queue. One op is one element that passes
+// through the queue. If vals holds pointers, every element gets a fresh
+// allocation, so that elements kept alive by the queue show up in the
+// retained memory.
+func benchChurn[T any](newQueue func() queue[T], vals []T, n int) func(b *testing.B) {
+	const base = 10
+	var zero T
+	_, isPtr := interface{}(zero).(*bigElem)
+	next := func(i int) T {
+		if isPtr {
+			return interface{}(&bigElem{ID: int64(i)}).(T)
+		}
+		return vals[i%len(vals)]
+	}
+	return func(b *testing.B) {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		q := newQueue()
+		for i := 0; i < base; i++ {
+			q.Put(next(i))
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		var last T
+		for i := 0; i < b.N; i++ {
+			q.Put(next(i))
+			if (i+1)%(n-base) != 0 && i < b.N-1 {
+				continue
+			}
+			for j := (i % (n - base)) + 1; j > 0; j-- {
+				last = q.Get()
+			}
+		}
+		b.StopTimer()
+		sink = last
+		last = zero
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(after.HeapAlloc)-float64(before.HeapAlloc), "B-retained")
+		runtime.KeepAlive(q)
+	}
+}
+
+// indexTable compares Lookup and Range on a cabinet of bigElem with and
+// without an index on the field. ID has unique values and gets an ordered
+// index; Payload is an array, gets a hash index, and matches one in a
+// hundred elements. The "steady" rows show the cost of keeping both indexes
+// up to date: one op is one Put and one Get.
+func indexTable() benchTable {
+	t := benchTable{
+		columns: []string{"scan", "index"},
+	}
+	for _, n := range []int{1000, 100000} {
+		n := n // the closures below must not share n
+		payload := [14]int64{7}
+		t.rows = append(t.rows,
+			benchRow{fmt.Sprintf("lookup/ID/%d", n), []func(*testing.B){
+				benchLookup(n, "", func(c *generics.IndexedCabinet) (*generics.Cabinet, error) { return c.Lookup("ID", n/2) }),
+				benchLookup(n, "ID", func(c *generics.IndexedCabinet) (*generics.Cabinet, error) { return c.Lookup("ID", n/2) }),
+			}},
+			benchRow{fmt.Sprintf("range/ID/%d", n), []func(*testing.B){
+				benchLookup(n, "", func(c *generics.IndexedCabinet) (*generics.Cabinet, error) { return c.Range("ID", n/2, n/2+9) }),
+				benchLookup(n, "ID", func(c *generics.IndexedCabinet) (*generics.Cabinet, error) { return c.Range("ID", n/2, n/2+9) }),
+			}},
+			benchRow{fmt.Sprintf("lookup/Payload/%d", n), []func(*testing.B){
+				benchLookup(n, "", func(c *generics.IndexedCabinet) (*generics.Cabinet, error) { return c.Lookup("Payload", payload) }),
+				benchLookup(n, "Payload", func(c *generics.IndexedCabinet) (*generics.Cabinet, error) { return c.Lookup("Payload", payload) }),
+			}},
+			benchRow{fmt.Sprintf("steady/%d", n), []func(*testing.B){
+				benchIndexedQueue(n, false),
+				benchIndexedQueue(n, true),
+			}},
+		)
+	}
+	return t
+}
+
+// indexedCabinet returns a cabinet of n bigElems, with indexes on the given
+// fields.
+func indexedCabinet(n int, fields ...string) *generics.IndexedCabinet {
+	c := generics.NewIndexedCabinet(reflect.TypeOf(bigElem{}))
+	for i := 0; i < n; i++ {
+		c.Put(bigElem{ID: int64(i), Payload: [14]int64{int64(i % 100)}})
+	}
+	for _, f := range fields {
+		if err := c.CreateIndex(f); err != nil {
+			panic(err)
+		}
+	}
+	return c
+}
+
+// benchLookup runs find on a cabinet of n elements with an index on field,
+// or without an index if field is "".
+func benchLookup(n int, field string, find func(c *generics.IndexedCabinet) (*generics.Cabinet, error)) func(b *testing.B) {
+	return func(b *testing.B) {
+		c := indexedCabinet(n)
+		if field != "" {
+			c = indexedCabinet(n, field)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			res, err := find(c)
+			if err != nil || res.Len() == 0 {
+				b.Fatal("nothing found:", err)
+			}
+			sink = res
+		}
+	}
+}
+
+// benchIndexedQueue runs the steady workload on a cabinet of n elements,
+// with or without indexes on ID and Payload.
+func benchIndexedQueue(n int, indexed bool) func(b *testing.B) {
+	return func(b *testing.B) {
+		c := indexedCabinet(0)
+		if indexed {
+			c = indexedCabinet(0, "ID", "Payload")
+		}
+		get := func() bigElem {
+			var v bigElem
+			if err := c.Get(&v); err != nil {
+				b.Fatal(err)
+			}
+			return v
+		}
+		vals := make([]bigElem, 100)
+		for i := range vals {
+			vals[i] = bigElem{ID: int64(i), Payload: [14]int64{int64(i % 10)}}
+		}
+		workload(b, func(v bigElem) { c.Put(v) }, get, vals, n, false)
+	}
+}
+
+// handoffTable compares queues that pass uint32 elements from concurrent
+// producers to concurrent consumers: the lock-free MPMC capsule, the
+// mutex-based bounded capsule, and a buffered channel, all with the same
+// capacity. One op is one element that passes through the queue.
+func handoffTable() benchTable {
+	t := benchTable{
+		columns: []string{"MPMC", "mutex", "channel"},
+	}
+	const size = 1024
+	for _, n := range []int{1, 2, 4, 8} {
+		n := n // the closures below must not share n
+		t.rows = append(t.rows, benchRow{fmt.Sprintf("handoff/%dx%d", n, n), []func(*testing.B){
+			benchHandoff(n, func() (func(uint32), func() uint32) {
+				q := capsule.NewUint32MPMCCapsule(size)
+				return q.Put, q.Get
+			}),
+			benchHandoff(n, func() (func(uint32), func() uint32) {
+				q := capsule.NewBoundedUint32Capsule(size, capsule.Block)
+				put := func(v uint32) { q.Put(context.Background(), v) }
+				get := func() uint32 { v, _ := q.Get(context.Background()); return v }
+				return put, get
+			}),
+			benchHandoff(n, func() (func(uint32), func() uint32) {
+				ch := make(chan uint32, size)
+				return func(v uint32) { ch <- v }, func() uint32 { return <-ch }
+			}),
+		}})
+	}
+	return t
+}
+
+// benchHandoff runs n producers and n consumers on the queue that newQueue
+// returns the put and get functions of.
+func benchHandoff(n int, newQueue func() (put func(uint32), get func() uint32)) func(b *testing.B) {
+	return func(b *testing.B) {
+		put, get := newQueue()
+		// share returns how many of the b.N elements goroutine i handles.
+		share := func(i int) int {
+			if i < b.N%n {
+				return b.N/n + 1
+			}
+			return b.N / n
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(2)
+			go func(k int) {
+				defer wg.Done()
+				for j := 0; j < k; j++ {
+					put(uint32(j))
+				}
+			}(share(i))
+			go func(k int) {
+				defer wg.Done()
+				for j := 0; j < k; j++ {
+					get()
+				}
+			}(share(i))
+		}
+		wg.Wait()
+	}
+}
+
+// spscTable compares queues between one producer and one consumer: the
+// SPSC capsule, a Uint32Capsule guarded by a mutex, and a buffered channel.
+// The "batch" rows move the elements in batches, through PutN and GetN or
+// under a single lock; channels have no batch operations. One op is one
+// element that passes through the queue.
+func spscTable() benchTable {
+	t := benchTable{
+		columns: []string{"SPSC", "mutex", "channel"},
+	}
+	const size = 1024
+	t.rows = append(t.rows, benchRow{"spsc/single", []func(*testing.B){
+		benchHandoff(1, func() (func(uint32), func() uint32) {
+			q := capsule.NewUint32SPSCCapsule(size)
+			return q.Put, q.Get
+		}),
+		benchHandoff(1, func() (func(uint32), func() uint32) {
+			q := &mutexQueue{}
+			return func(v uint32) { q.PutN([]uint32{v}) }, func() uint32 {
+				var v [1]uint32
+				for q.GetN(v[:]) == 0 {
+					runtime.Gosched()
+				}
+				return v[0]
+			}
+		}),
+		benchHandoff(1, func() (func(uint32), func() uint32) {
+			ch := make(chan uint32, size)
+			return func(v uint32) { ch <- v }, func() uint32 { return <-ch }
+		}),
+	}})
+	for _, batch := range []int{16, 256} {
+		t.rows = append(t.rows, benchRow{fmt.Sprintf("spsc/batch%d", batch), []func(*testing.B){
+			benchBatchHandoff(batch, func() (func([]uint32) int, func([]uint32) int) {
+				q := capsule.NewUint32SPSCCapsule(size)
+				return q.PutN, q.GetN
+			}),
+			benchBatchHandoff(batch, func() (func([]uint32) int, func([]uint32) int) {
+				q := &mutexQueue{}
+				return q.PutN, q.GetN
+			}),
+			nil,
+		}})
+	}
+	return t
+}
+
+// mutexQueue is a Uint32Capsule guarded by a mutex. It has no capacity
+// limit.
+type mutexQueue struct {
+	mu sync.Mutex
+	q  capsule.Uint32Capsule
+}
+
+func (q *mutexQueue) PutN(vals []uint32) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, v := range vals {
+		q.q.Put(v)
+	}
+	return len(vals)
+}
+
+func (q *mutexQueue) GetN(dst []uint32) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := min(len(dst), q.q.Len())
+	for i := range dst[:n] {
+		dst[i] = q.q.Get()
+	}
+	return n
+}
+
+// benchBatchHandoff passes b.N elements from one producer to one consumer,
+// in batches of the given size.
+func benchBatchHandoff(batch int, newQueue func() (putN, getN func([]uint32) int)) func(b *testing.B) {
+	return func(b *testing.B) {
+		putN, getN := newQueue()
+		b.ReportAllocs()
+		b.ResetTimer()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			buf := make([]uint32, batch)
+			for got := 0; got < b.N; {
+				n := getN(buf[:min(batch, b.N-got)])
+				if n == 0 {
+					runtime.Gosched()
+				}
+				got += n
+			}
+		}()
+		buf := make([]uint32, batch)
+		for put := 0; put < b.N; {
+			n := putN(buf[:min(batch, b.N-put)])
+			if n == 0 {
+				runtime.Gosched()
+			}
+			put += n
+		}
+		<-done
+	}
+}
+
+// persistTable shows the cost of persistence: a Uint32Capsule in memory
+// compared with a persistent capsule that writes its log without and with
+// an fsync per record. The workloads are those of techniqueTable.
+func persistTable() benchTable {
+	t := benchTable{
+		columns: []string{"memory", "log", "log+fsync"},
+	}
+	ints := []uint32{1, 2, 3, 5, 8, 13, 21, 34}
+	for _, burst := range []bool{true, false} {
+		workload := "steady"
+		if burst {
+			workload = "burst"
+		}
+		for _, n := range []int{10, 1000} {
+			t.rows = append(t.rows, benchRow{fmt.Sprintf("persist/%s/%d", workload, n), []func(*testing.B){
+				benchQueue(func() queue[uint32] { return capsule.NewUint32Capsule() }, ints, n, burst),
+				benchPersistent(capsule.PersistOptions{NoSync: true}, ints, n, burst),
+				benchPersistent(capsule.PersistOptions{}, ints, n, burst),
+			}})
+		}
+	}
+	return t
+}
+
+// benchPersistent runs the workload on a persistent capsule in a temporary
+// directory.
+func benchPersistent(opts capsule.PersistOptions, vals []uint32, n int, burst bool) func(b *testing.B) {
+	return func(b *testing.B) {
+		dir, err := os.MkdirTemp("", "capsule")
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		c, err := capsule.OpenPersistentUint32Capsule(dir, capsule.BinaryUint32Codec{}, opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer c.Close()
+		put := func(v uint32) {
+			if err := c.Put(v); err != nil {
+				b.Fatal(err)
+			}
+		}
+		get := func() uint32 {
+			v, ok, err := c.TryGet()
+			if err != nil || !ok {
+				b.Fatal(ok, err)
+			}
+			return v
+		}
+		workload(b, put, get, vals, n, burst)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// NewCabinetFromSlice creates a cabinet that adopts slice, which must be a
+// slice of any type, without copying it. The cabinet and the caller share
+// the backing array until the cabinet outgrows it, so the caller should not
+// modify the slice afterwards.
+func NewCabinetFromSlice(slice interface{}) (*Cabinet, error) {
+	v := reflect.ValueOf(slice)
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("NewCabinetFromSlice: expected a slice, got %T: %w", slice, ErrWrongType)
+	}
+	return &Cabinet{s: v}, nil
+}
+
+// Len returns the number of elements in the cabinet.
+func (c *Cabinet) Len() int {
+	return c.s.Len()
+}
+
+// Slice returns the elements as a native slice, e.g. a []float64 for a
+// cabinet of float64, which the caller can get with a type assertion. The
+// slice shares its backing array with the cabinet; use CopyTo to get an
+// independent copy.
+func (c *Cabinet) Slice() interface{} {
+	return c.s.Interface()
+}
+
+// CopyTo sets the slice that dst points to to a copy of the elements. dst
+// must be a non-nil pointer to a slice whose elements the cabinet's elements
+// are assignable to, e.g. a *[]float64 for a cabinet of float64, or a
+// *[]io.Reader for a cabinet of *os.File.
+func (c *Cabinet) CopyTo(dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("CopyTo: expected a non-nil pointer to a slice, got %T: %w", dst, ErrWrongType)
+	}
+	st := v.Elem().Type()
+	if !c.elemType().AssignableTo(st.Elem()) {
+		return fmt.Errorf("CopyTo: cannot store a %s into a %s: %w", c.elemType(), st, ErrWrongType)
+	}
+	cp := reflect.MakeSlice(st, c.Len(), c.Len())
+	if st.Elem() == c.elemType() {
+		reflect.Copy(cp, c.s)
+	} else {
+		for i := 0; i < c.Len(); i++ {
+			cp.Index(i).Set(c.s.Index(i))
+		}
+	}
+	v.Elem().Set(cp)
+	return nil
+}
+
+// Index stores the element at index i in the variable that ptr points to,
+// without removing it from the cabinet.
+func (c *Cabinet) Index(i int, ptr interface{}) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("Index: expected a non-nil pointer, got %T: %w", ptr, ErrWrongType)
+	}
+	if !c.elemType().AssignableTo(v.Elem().Type()) {
+		return fmt.Errorf("Index: cannot store a %s into a %s: %w", c.elemType(), v.Elem().Type(), ErrWrongType)
+	}
+	if i < 0 || i >= c.Len() {
+		return fmt.Errorf("Index: index %d out of range [0:%d]", i, c.Len())
+	}
+	v.Elem().Set(c.s.Index(i))
+	return nil
+}
+
+// elemType returns the element type of the cabinet.
+func (c *Cabinet) elemType() reflect.Type {
+	return c.s.Type().Elem()
+}
+
+// PutConverted is like Put, but converts numbers to the element type of
+// the cabinet, so that, for example, a cabinet of float64 accepts an int.
+// Conversions that would change the value, like 3.5 to an int or 300 to a
+// uint8, are refused.
+func (c *Cabinet) PutConverted(val interface{}) error {
+	v, err := elemValue(c.elemType(), val, true)
+	if err != nil {
+		return fmt.Errorf("PutConverted: %w", err)
+	}
+	c.s = reflect.Append(c.s, v)
+	return nil
+}
+
+// value returns val as a reflect.Value that can be stored in the cabinet.
+func (c *Cabinet) value(val interface{}) (reflect.Value, error) {
+	return elemValue(c.elemType(), val, false)
+}
+
+// elemValue returns val as a reflect.Value that can be stored as an element
+// of type et, converting numbers if convert is set.
+func elemValue(et reflect.Type, val interface{}, convert bool) (reflect.Value, error) {
+	if val == nil {
+		// An untyped nil fits into pointers, interfaces, and the like.
+		if !canBeNil(et.Kind()) {
+			return reflect.Value{}, fmt.Errorf("cannot put nil into a cabinet of %s: %w", et, ErrWrongType)
+		}
+		return reflect.Zero(et), nil
+	}
+	v := reflect.ValueOf(val)
+	if v.Type().AssignableTo(et) {
+		return v, nil
+	}
+	if convert && isNumber(v.Kind()) && isNumber(et.Kind()) && v.Type().ConvertibleTo(et) {
+		if cv, ok := convertNumber(v, et); ok {
+			return cv, nil
+		}
+		return reflect.Value{}, fmt.Errorf("cannot convert %v (%s) to %s without changing its value: %w", val, v.Type(), et, ErrWrongType)
+	}
+	return reflect.Value{}, fmt.Errorf("cannot put a %s into a cabinet of %s: %w", v.Type(), et, ErrWrongType)
+}
+
+// isNumber reports whether k is an integer or floating-point kind. Complex
+// numbers are left out, as they do not convert to or from the other kinds.
+func isNumber(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64 && k != reflect.Uintptr
+}
+
+// convertNumber converts v to the numeric type t. It fails if the value
+// does not survive the conversion. Conversions to floating-point types only
+// fail on overflow, as floats are approximations anyway.
+func convertNumber(v reflect.Value, t reflect.Type) (reflect.Value, bool) {
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64:
+		if v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64 {
+			if reflect.Zero(t).OverflowFloat(v.Float()) {
+				return reflect.Value{}, false
+			}
+		}
+		return v.Convert(t), true
+	}
+	cv := v.Convert(t)
+	if !cv.Convert(v.Type()).Equal(v) {
+		return reflect.Value{}, false
+	}
+	// Converting back and forth may still hide a change of sign, as in
+	// int8(-1) -> uint8(255) -> int8(-1).
+	if isNegative(v) != isNegative(cv) {
+		return reflect.Value{}, false
+	}
+	return cv, true
+}
+
+// isNegative reports whether the number v is less than zero.
+func isNegative(v reflect.Value) bool {
+	switch {
+	case v.CanInt():
+		return v.Int() < 0
+	case v.CanFloat():
+		return v.Float() < 0
+	}
+	return false
+}
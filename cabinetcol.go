@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A ColumnarCabinet stores structs as a struct of arrays: every field lives
+// in a slice of its own. Put takes whole structs apart, and Get puts them
+// back together. Column and Sum, on the other hand, only touch the slices
+// of the fields they need, which makes them faster on wide structs. For
+// everything else, Rows returns an ordinary cabinet with the assembled
+// structs.
+type ColumnarCabinet struct {
+	typ    reflect.Type    // the struct type
+	fields []reflect.Value // one slice per field of typ
+	n      int             // number of elements
+}
+
+// NewColumnarCabinet creates a columnar cabinet for structs of type t. All
+// fields of t must be exported, as the cabinet has to set them when it
+// assembles a struct.
+func NewColumnarCabinet(t reflect.Type) (*ColumnarCabinet, error) {
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("NewColumnarCabinet: expected a struct type, got %v: %w", t, ErrWrongType)
+	}
+	c := &ColumnarCabinet{typ: t, fields: make([]reflect.Value, t.NumField())}
+	for i := range c.fields {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			return nil, fmt.Errorf("NewColumnarCabinet: field %s of %s is not exported: %w", f.Name, t, ErrWrongType)
+		}
+		c.fields[i] = reflect.MakeSlice(reflect.SliceOf(f.Type), 0, 10)
+	}
+	return c, nil
+}
+
+// Put appends the struct val to the columns.
+func (c *ColumnarCabinet) Put(val interface{}) error {
+	v, err := elemValue(c.typ, val, false)
+	if err != nil {
+		return fmt.Errorf("Put: %w", err)
+	}
+	for i := range c.fields {
+		c.fields[i] = reflect.Append(c.fields[i], v.Field(i))
+	}
+	c.n++
+	return nil
+}
+
+// Get removes the first struct and stores it in the variable that retref
+// points to, as Cabinet.Get does.
+func (c *ColumnarCabinet) Get(retref interface{}) error {
+	ref := reflect.ValueOf(retref)
+	if ref.Kind() != reflect.Ptr {
+		return fmt.Errorf("Get: expected a pointer, got %T: %w", retref, ErrWrongType)
+	}
+	if ref.IsNil() {
+		return fmt.Errorf("Get: cannot store into a nil %T: %w", retref, ErrWrongType)
+	}
+	if !c.typ.AssignableTo(ref.Elem().Type()) {
+		return fmt.Errorf("Get: cannot store a %s into a %s: %w", c.typ, ref.Elem().Type(), ErrWrongType)
+	}
+	if c.n == 0 {
+		return ErrEmpty
+	}
+	ref.Elem().Set(c.row(0))
+	for i, col := range c.fields {
+		col.Index(0).Set(reflect.Zero(col.Type().Elem())) // do not keep the element alive
+		c.fields[i] = col.Slice(1, col.Len())
+	}
+	c.n--
+	return nil
+}
+
+// Len returns the number of elements in the cabinet.
+func (c *ColumnarCabinet) Len() int {
+	return c.n
+}
+
+// Rows returns an ordinary cabinet with the structs, assembled from the
+// columns.
+func (c *ColumnarCabinet) Rows() *Cabinet {
+	s := reflect.MakeSlice(reflect.SliceOf(c.typ), c.n, c.n)
+	for j, col := range c.fields {
+		for i := 0; i < c.n; i++ {
+			s.Index(i).Field(j).Set(col.Index(i))
+		}
+	}
+	return &Cabinet{s: s}
+}
+
+// row assembles the element at index i.
+func (c *ColumnarCabinet) row(i int) reflect.Value {
+	v := reflect.New(c.typ).Elem()
+	for j, col := range c.fields {
+		v.Field(j).Set(col.Index(i))
+	}
+	return v
+}
+
+// Column returns the values of a field of all elements as a new slice,
+// e.g. a []string for a string field, which the caller can get with a type
+// assertion. The field is named as in SortBy and must be exported. Elements
+// with a nil pointer on the way to the field contribute the zero value.
+func (c *Cabinet) Column(field string) (interface{}, error) {
+	return column(c.elemType(), c.Len(), field, c.scanField)
+}
+
+// Column returns the values of a field as Cabinet.Column does.
+func (c *ColumnarCabinet) Column(field string) (interface{}, error) {
+	return column(c.typ, c.n, field, c.scanField)
+}
+
+// column implements Column for a cabinet with n elements of type t, whose
+// field values scan reads.
+func column(t reflect.Type, n int, field string, scan func(p path, f func(i int, v reflect.Value))) (interface{}, error) {
+	p, err := fieldPath(t, field)
+	if err != nil {
+		return nil, fmt.Errorf("Column: %w", err)
+	}
+	if p.unexported {
+		return nil, fmt.Errorf("Column: field %s is not exported: %w", field, ErrWrongType)
+	}
+	res := reflect.MakeSlice(reflect.SliceOf(p.typ), n, n)
+	scan(p, func(i int, v reflect.Value) {
+		res.Index(i).Set(v)
+	})
+	return res.Interface(), nil
+}
+
+// Sum returns the sum of a numeric field over all elements. The field is
+// named as in SortBy. Elements with a nil pointer on the way to the field
+// do not count.
+func (c *Cabinet) Sum(field string) (float64, error) {
+	return sum(c.elemType(), field, c.scanField)
+}
+
+// Sum returns the sum of a numeric field as Cabinet.Sum does.
+func (c *ColumnarCabinet) Sum(field string) (float64, error) {
+	return sum(c.typ, field, c.scanField)
+}
+
+// sum implements Sum for a cabinet with elements of type t, whose field
+// values scan reads.
+func sum(t reflect.Type, field string, scan func(p path, f func(i int, v reflect.Value))) (float64, error) {
+	p, num, err := numericField("Sum", t, field)
+	if err != nil {
+		return 0, err
+	}
+	sum := 0.0
+	scan(p, func(_ int, v reflect.Value) {
+		sum += num(v)
+	})
+	return sum, nil
+}
+
+// numericField resolves a numeric field of the struct type t for op. It
+// also returns a function that reads the field's values as float64.
+func numericField(op string, t reflect.Type, field string) (path, func(v reflect.Value) float64, error) {
+	p, err := fieldPath(t, field)
+	if err != nil {
+		return path{}, nil, fmt.Errorf("%s: %w", op, err)
+	}
+	num := numberOf(p.typ)
+	if num == nil {
+		return path{}, nil, fmt.Errorf("%s: field %s of type %s is not numeric: %w", op, field, p.typ, ErrWrongType)
+	}
+	return p, num, nil
+}
+
+// numberOf returns a function that turns values of type t into a float64,
+// or nil if t is not a number type.
+func numberOf(t reflect.Type) func(v reflect.Value) float64 {
+	switch k := t.Kind(); {
+	case k >= reflect.Int && k <= reflect.Int64:
+		return func(v reflect.Value) float64 { return float64(v.Int()) }
+	case k >= reflect.Uint && k <= reflect.Uint64:
+		return func(v reflect.Value) float64 { return float64(v.Uint()) }
+	case k == reflect.Float32 || k == reflect.Float64:
+		return func(v reflect.Value) float64 { return v.Float() }
+	}
+	return nil
+}
+
+// scanField calls f with the index and the field value of every element
+// that has no nil pointer on the way to the field.
+func (c *Cabinet) scanField(p path, f func(i int, v reflect.Value)) {
+	for i := 0; i < c.Len(); i++ {
+		if v, ok := p.get(c.s.Index(i)); ok {
+			f(i, v)
+		}
+	}
+}
+
+// scanField is Cabinet.scanField for a columnar cabinet. It reads the field
+// from its column, without assembling the elements.
+func (c *ColumnarCabinet) scanField(p path, f func(i int, v reflect.Value)) {
+	// The first step of the path selects the column, the rest leads from
+	// the column's values to the field.
+	col := c.fields[p.index[0][0]]
+	rest := path{index: p.index[1:], typ: p.typ}
+	if len(p.index[0]) > 1 {
+		rest.index = append([][]int{p.index[0][1:]}, rest.index...)
+	}
+	for i := 0; i < col.Len(); i++ {
+		v, ok := col.Index(i), true
+		if len(rest.index) > 0 {
+			v, ok = rest.get(v)
+		}
+		if ok {
+			f(i, v)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type item struct {
+	Name  string
+	Price float64
+	Qty   int
+}
+
+func TestColumnar(t *testing.T) {
+	c, err := NewColumnarCabinet(reflect.TypeOf(item{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := []item{{"a", 1.5, 2}, {"b", 2.5, 1}, {"c", 4, 3}}
+	for _, it := range items {
+		if err := c.Put(it); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Put("d"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Put(string) = %v, want ErrWrongType", err)
+	}
+	if sum, err := c.Sum("Price"); err != nil || sum != 8 {
+		t.Errorf("Sum(Price) = %v, %v, want 8", sum, err)
+	}
+	names, err := c.Column("Name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b", "c"}) {
+		t.Errorf("Column(Name) = %v", names)
+	}
+	if got := c.Rows().Slice(); !reflect.DeepEqual(got, items) {
+		t.Errorf("Rows = %v, want %v", got, items)
+	}
+	var it item
+	if err := c.Get(&it); err != nil || it != items[0] {
+		t.Errorf("Get = %v, %v, want %v", it, err, items[0])
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len = %d, want 2", c.Len())
+	}
+	if sum, _ := c.Sum("Qty"); sum != 4 {
+		t.Errorf("Sum(Qty) after Get = %v, want 4", sum)
+	}
+	var s string
+	if err := c.Get(&s); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Get(*string) = %v, want ErrWrongType", err)
+	}
+	c.Get(&it)
+	c.Get(&it)
+	if err := c.Get(&it); err != ErrEmpty {
+		t.Errorf("Get from empty cabinet = %v, want ErrEmpty", err)
+	}
+	type hidden struct{ x int }
+	if _, err := NewColumnarCabinet(reflect.TypeOf(hidden{})); !errors.Is(err, ErrWrongType) {
+		t.Errorf("NewColumnarCabinet(unexported field) = %v, want ErrWrongType", err)
+	}
+}
+
+func TestPutConverted(t *testing.T) {
+	c := NewCabinet(reflect.TypeOf(uint8(0)))
+	if err := c.Put(7); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Put(int) = %v, want ErrWrongType", err)
+	}
+	if err := c.PutConverted(7); err != nil {
+		t.Errorf("PutConverted(7) = %v", err)
+	}
+	for _, v := range []interface{}{300, -1, 2.5} {
+		if err := c.PutConverted(v); !errors.Is(err, ErrWrongType) {
+			t.Errorf("PutConverted(%v) = %v, want ErrWrongType", v, err)
+		}
+	}
+	if got := c.Slice().([]uint8); !reflect.DeepEqual(got, []uint8{7}) {
+		t.Errorf("Slice = %v, want [7]", got)
+	}
+}
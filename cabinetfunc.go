@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// The functional operations below take their functions as interface{}, so
+// they can work on any element type. Each operation checks the signature of
+// its function against the element type before it calls the function for
+// the first time, and fails with an error wrapping ErrWrongType if they do
+// not match. A function may return an additional error as its last result;
+// the operation then stops at the first non-nil error and returns it.
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// fn describes a function passed to one of the functional operations.
+type fn struct {
+	v        reflect.Value
+	hasError bool // the last result is an error
+}
+
+// checkFunc checks that f is a function that accepts arguments of the types
+// in, and that returns one result (if wantResult is true) or none, optionally
+// followed by an error.
+func checkFunc(op string, f interface{}, in []reflect.Type, wantResult bool) (fn, error) {
+	v := reflect.ValueOf(f)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return fn{}, fmt.Errorf("%s: expected a function, got %T: %w", op, f, ErrWrongType)
+	}
+	t := v.Type()
+	if t.IsVariadic() || t.NumIn() != len(in) {
+		return fn{}, fmt.Errorf("%s: %s must take %d argument(s): %w", op, t, len(in), ErrWrongType)
+	}
+	for i, want := range in {
+		if !want.AssignableTo(t.In(i)) {
+			return fn{}, fmt.Errorf("%s: %s cannot take a %s as argument %d: %w", op, t, want, i+1, ErrWrongType)
+		}
+	}
+	results := 0
+	if wantResult {
+		results = 1
+	}
+	hasError := t.NumOut() == results+1 && t.Out(results) == errorType
+	if t.NumOut() != results && !hasError {
+		return fn{}, fmt.Errorf("%s: %s must return %d result(s), optionally followed by an error: %w", op, t, results, ErrWrongType)
+	}
+	return fn{v, hasError}, nil
+}
+
+// call calls f and splits off the error result, if any.
+func (f fn) call(args ...reflect.Value) ([]reflect.Value, error) {
+	out := f.v.Call(args)
+	if !f.hasError {
+		return out, nil
+	}
+	last := out[len(out)-1]
+	if !last.IsNil() {
+		return nil, last.Interface().(error)
+	}
+	return out[:len(out)-1], nil
+}
+
+// Map calls fn for every element and returns a new cabinet with the
+// results. fn must be a func(E) R or a func(E) (R, error), where E is the
+// element type of c. The new cabinet has elements of type R.
+func (c *Cabinet) Map(fn interface{}) (*Cabinet, error) {
+	f, err := checkFunc("Map", fn, []reflect.Type{c.elemType()}, true)
+	if err != nil {
+		return nil, err
+	}
+	res := NewCabinet(f.v.Type().Out(0))
+	for i := 0; i < c.Len(); i++ {
+		out, err := f.call(c.s.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		res.s = reflect.Append(res.s, out[0])
+	}
+	return res, nil
+}
+
+// Filter returns a new cabinet with the elements for which pred returns
+// true. pred must be a func(E) bool or a func(E) (bool, error).
+func (c *Cabinet) Filter(pred interface{}) (*Cabinet, error) {
+	f, err := checkFunc("Filter", pred, []reflect.Type{c.elemType()}, true)
+	if err != nil {
+		return nil, err
+	}
+	if f.v.Type().Out(0).Kind() != reflect.Bool {
+		return nil, fmt.Errorf("Filter: %s must return a bool: %w", f.v.Type(), ErrWrongType)
+	}
+	res := &Cabinet{s: reflect.MakeSlice(c.s.Type(), 0, 10)}
+	for i := 0; i < c.Len(); i++ {
+		v := c.s.Index(i)
+		out, err := f.call(v)
+		if err != nil {
+			return nil, err
+		}
+		if out[0].Bool() {
+			res.s = reflect.Append(res.s, v)
+		}
+	}
+	return res, nil
+}
+
+// Reduce combines all elements into one value: It calls fn(acc, elem) for
+// every element, where acc is init for the first call and the result of the
+// previous call otherwise, and returns the result of the last call. fn must
+// be a func(A, E) A or a func(A, E) (A, error), and init must be assignable
+// to A.
+func (c *Cabinet) Reduce(fn, init interface{}) (interface{}, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.Type().NumIn() == 0 {
+		return nil, fmt.Errorf("Reduce: expected a function of two arguments, got %T: %w", fn, ErrWrongType)
+	}
+	accType := v.Type().In(0)
+	f, err := checkFunc("Reduce", fn, []reflect.Type{accType, c.elemType()}, true)
+	if err != nil {
+		return nil, err
+	}
+	if f.v.Type().Out(0) != accType {
+		return nil, fmt.Errorf("Reduce: %s must return its first argument's type: %w", f.v.Type(), ErrWrongType)
+	}
+	acc := reflect.Zero(accType)
+	if init != nil {
+		acc = reflect.ValueOf(init)
+		if !acc.Type().AssignableTo(accType) {
+			return nil, fmt.Errorf("Reduce: cannot use a %s as initial value for %s: %w", acc.Type(), f.v.Type(), ErrWrongType)
+		}
+	} else if !canBeNil(accType.Kind()) {
+		return nil, fmt.Errorf("Reduce: cannot use nil as initial value for %s: %w", f.v.Type(), ErrWrongType)
+	}
+	for i := 0; i < c.Len(); i++ {
+		out, err := f.call(acc, c.s.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		acc = out[0]
+	}
+	return acc.Interface(), nil
+}
+
+// ForEach calls fn for every element. fn must be a func(E) or a
+// func(E) error; in the latter case, ForEach stops at the first error and
+// returns it.
+func (c *Cabinet) ForEach(fn interface{}) error {
+	f, err := checkFunc("ForEach", fn, []reflect.Type{c.elemType()}, false)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < c.Len(); i++ {
+		if _, err := f.call(c.s.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Avg returns the average of a numeric field over all elements. The field
+// is named as in SortBy. Elements with a nil pointer on the way to the
+// field do not count; if no element counts, Avg returns ErrEmpty.
+func (c *Cabinet) Avg(field string) (float64, error) {
+	p, num, err := numericField("Avg", c.elemType(), field)
+	if err != nil {
+		return 0, err
+	}
+	sum, n := 0.0, 0
+	c.scanField(p, func(_ int, v reflect.Value) {
+		sum += num(v)
+		n++
+	})
+	if n == 0 {
+		return 0, fmt.Errorf("Avg: %w", ErrEmpty)
+	}
+	return sum / float64(n), nil
+}
+
+// Min returns the smallest value of a field over all elements. The field
+// is named as in SortBy and must be exported and of a type that SortBy can
+// sort by. Elements with a nil pointer on the way to the field do not
+// count; if no element counts, Min returns ErrEmpty.
+func (c *Cabinet) Min(field string) (interface{}, error) {
+	return c.extreme("Min", field, -1)
+}
+
+// Max returns the largest value of a field over all elements, like Min.
+func (c *Cabinet) Max(field string) (interface{}, error) {
+	return c.extreme("Max", field, 1)
+}
+
+// extreme returns the minimum (for sign -1) or maximum (for sign 1) of a
+// field.
+func (c *Cabinet) extreme(op, field string, sign int) (interface{}, error) {
+	p, cmp, err := orderedField(op, c.elemType(), field)
+	if err != nil {
+		return nil, err
+	}
+	var best reflect.Value
+	c.scanField(p, func(_ int, v reflect.Value) {
+		if !best.IsValid() || cmp(v, best)*sign > 0 {
+			best = v
+		}
+	})
+	if !best.IsValid() {
+		return nil, fmt.Errorf("%s: %w", op, ErrEmpty)
+	}
+	return best.Interface(), nil
+}
+
+// Distinct returns the distinct values of a field as a new slice, e.g. a
+// []string for a string field, in the order in which they first occur. The
+// field is named as in SortBy and must be exported and of a comparable
+// type other than an interface type. Elements with a nil pointer on the way
+// to the field do not count. A field value that cannot be compared, such as
+// a slice in an interface field, is an error.
+func (c *Cabinet) Distinct(field string) (interface{}, error) {
+	p, err := comparableField("Distinct", c.elemType(), field)
+	if err != nil {
+		return nil, err
+	}
+	res := reflect.MakeSlice(reflect.SliceOf(p.typ), 0, 10)
+	seen := map[interface{}]bool{}
+	c.scanField(p, func(_ int, v reflect.Value) {
+		if err != nil {
+			return
+		}
+		if !v.Comparable() {
+			err = uncomparable("Distinct", field, v)
+			return
+		}
+		if k := v.Interface(); !seen[k] {
+			seen[k] = true
+			res = reflect.Append(res, v)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Interface(), nil
+}
+
+// orderedField resolves an exported field of the struct type t whose values
+// op compares with each other.
+func orderedField(op string, t reflect.Type, field string) (path, func(a, b reflect.Value) int, error) {
+	p, err := fieldPath(t, field)
+	if err != nil {
+		return path{}, nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if p.unexported {
+		return path{}, nil, fmt.Errorf("%s: field %s is not exported: %w", op, field, ErrWrongType)
+	}
+	cmp, err := comparer(p.typ)
+	if err != nil {
+		return path{}, nil, fmt.Errorf("%s: field %s: %w", op, field, err)
+	}
+	return p, cmp, nil
+}
+
+// comparableField resolves an exported field of the struct type t whose
+// values op uses as map keys.
+func comparableField(op string, t reflect.Type, field string) (path, error) {
+	p, err := fieldPath(t, field)
+	if err != nil {
+		return path{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if p.unexported {
+		return path{}, fmt.Errorf("%s: field %s is not exported: %w", op, field, ErrWrongType)
+	}
+	if !p.typ.Comparable() || p.typ.Kind() == reflect.Interface {
+		return path{}, fmt.Errorf("%s: values of field %s of type %s are not comparable: %w", op, field, p.typ, ErrWrongType)
+	}
+	return p, nil
+}
+
+// uncomparable returns the error for a value v of a field of a comparable
+// type that holds a value that cannot be compared in an interface.
+func uncomparable(op, field string, v reflect.Value) error {
+	return fmt.Errorf("%s: field %s holds %v, which cannot be compared: %w", op, field, v, ErrWrongType)
+}
+
+// Groups is the result of GroupBy. It maps the values of the field that the
+// elements were grouped by to cabinets with the elements of each group.
+type Groups struct {
+	path   path
+	keys   []interface{}
+	groups map[interface{}]*Cabinet
+	elem   reflect.Type // element type of the cabinets
+}
+
+// GroupBy groups the elements by the value of a field. The field is named
+// as in SortBy and must be exported and of a comparable type other than an
+// interface type. Elements with a nil pointer on the way to the field form
+// the group with the key nil. A field value that cannot be compared, such as
+// a slice in an interface field, is an error.
+func (c *Cabinet) GroupBy(field string) (*Groups, error) {
+	p, err := comparableField("GroupBy", c.elemType(), field)
+	if err != nil {
+		return nil, err
+	}
+	g := &Groups{path: p, groups: map[interface{}]*Cabinet{}, elem: c.elemType()}
+	for i := 0; i < c.Len(); i++ {
+		v := c.s.Index(i)
+		var k interface{}
+		if f, ok := p.get(v); ok {
+			if !f.Comparable() {
+				return nil, uncomparable("GroupBy", field, f)
+			}
+			k = f.Interface()
+		}
+		sub := g.groups[k]
+		if sub == nil {
+			sub = &Cabinet{s: reflect.MakeSlice(c.s.Type(), 0, 10)}
+			g.groups[k] = sub
+			g.keys = append(g.keys, k)
+		}
+		sub.s = reflect.Append(sub.s, v)
+	}
+	return g, nil
+}
+
+// Len returns the number of groups.
+func (g *Groups) Len() int {
+	return len(g.keys)
+}
+
+// Keys returns the keys of the groups, in the order in which they first
+// occur in the cabinet.
+func (g *Groups) Keys() []interface{} {
+	return append([]interface{}(nil), g.keys...)
+}
+
+// Group returns the cabinet with the elements of the group with the given
+// key, or nil if there is no such group. Numeric keys are converted to the
+// type of the field, as in Lookup.
+func (g *Groups) Group(key interface{}) *Cabinet {
+	if key == nil {
+		return g.groups[nil]
+	}
+	k, err := indexKey(g.path.typ, key)
+	if err != nil || !k.Comparable() {
+		return nil
+	}
+	return g.groups[k.Interface()]
+}
+
+// The aggregates of Groups compute the aggregate of the same name for every
+// group. Groups in which no element has a value for the field, due to nil
+// pointers on the way, are missing from the results of Avg, Min, and Max.
+
+// Count returns the number of elements per group.
+func (g *Groups) Count() map[interface{}]int {
+	res := make(map[interface{}]int, len(g.keys))
+	for k, sub := range g.groups {
+		res[k] = sub.Len()
+	}
+	return res
+}
+
+// Sum returns the sum of a numeric field per group.
+func (g *Groups) Sum(field string) (map[interface{}]float64, error) {
+	if _, _, err := numericField("Sum", g.elem, field); err != nil {
+		return nil, err
+	}
+	return aggregate(g, func(c *Cabinet) (float64, error) { return c.Sum(field) })
+}
+
+// Avg returns the average of a numeric field per group.
+func (g *Groups) Avg(field string) (map[interface{}]float64, error) {
+	if _, _, err := numericField("Avg", g.elem, field); err != nil {
+		return nil, err
+	}
+	return aggregate(g, func(c *Cabinet) (float64, error) { return c.Avg(field) })
+}
+
+// Min returns the smallest value of a field per group.
+func (g *Groups) Min(field string) (map[interface{}]interface{}, error) {
+	if _, _, err := orderedField("Min", g.elem, field); err != nil {
+		return nil, err
+	}
+	return aggregate(g, func(c *Cabinet) (interface{}, error) { return c.Min(field) })
+}
+
+// Max returns the largest value of a field per group.
+func (g *Groups) Max(field string) (map[interface{}]interface{}, error) {
+	if _, _, err := orderedField("Max", g.elem, field); err != nil {
+		return nil, err
+	}
+	return aggregate(g, func(c *Cabinet) (interface{}, error) { return c.Max(field) })
+}
+
+// Distinct returns the distinct values of a field per group, each as a
+// slice as returned by Cabinet.Distinct.
+func (g *Groups) Distinct(field string) (map[interface{}]interface{}, error) {
+	if _, err := comparableField("Distinct", g.elem, field); err != nil {
+		return nil, err
+	}
+	return aggregate(g, func(c *Cabinet) (interface{}, error) { return c.Distinct(field) })
+}
+
+// aggregate calls f for every group and collects the results. The field
+// has been checked already, so f fails only with ErrEmpty, which skips the
+// group, or on a value that cannot be compared.
+func aggregate[T any](g *Groups, f func(c *Cabinet) (T, error)) (map[interface{}]T, error) {
+	res := make(map[interface{}]T, len(g.keys))
+	for k, sub := range g.groups {
+		r, err := f(sub)
+		if errors.Is(err, ErrEmpty) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res[k] = r
+	}
+	return res, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestGroupUncomparable(t *testing.T) {
+	var a, s tagged
+	a.Name, a.Tag.X = "a", 1
+	s.Name, s.Tag.X = "s", []int{1}
+	c := NewCabinet(reflect.TypeOf(tagged{}))
+	c.Put(a)
+	c.Put(a)
+	got, err := c.Distinct("Tag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags := got.([]struct{ X interface{} }); len(tags) != 1 || tags[0] != a.Tag {
+		t.Errorf("Distinct(Tag) = %v, want [%v]", tags, a.Tag)
+	}
+	g, err := c.GroupBy("Tag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub := g.Group(a.Tag); sub == nil || sub.Len() != 2 {
+		t.Errorf("Group(%v) = %v, want 2 elements", a.Tag, sub)
+	}
+	if g.Group(s.Tag) != nil {
+		t.Errorf("Group(%v) is not nil", s.Tag)
+	}
+
+	c.Put(s)
+	if _, err := c.Distinct("Tag"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Distinct(Tag) = %v, want ErrWrongType", err)
+	}
+	if _, err := c.GroupBy("Tag"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("GroupBy(Tag) = %v, want ErrWrongType", err)
+	}
+	g, err = c.GroupBy("Name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Distinct("Tag"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Groups.Distinct(Tag) = %v, want ErrWrongType", err)
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// IndexedCabinet is a Cabinet with secondary indexes, which let Lookup and
+// Range find elements by a field without scanning the whole cabinet. Put,
+// PutConverted, and Get keep the indexes up to date, and SortBy rebuilds
+// them; all other methods are those of Cabinet.
+//
+// An index refers to an element by its sequence number, which is the
+// element's position plus the number of elements that Get has removed since
+// the last rebuild. This way, the numbers do not change when Get removes
+// the first element.
+type IndexedCabinet struct {
+	Cabinet
+	fields map[string]*fieldIndex
+	off    int // sequence number of the first element
+}
+
+// A fieldIndex maps the values of a field to the elements. If the field's
+// type is ordered (see comparer), the index also keeps the values sorted.
+type fieldIndex struct {
+	path path
+	cmp  func(a, b reflect.Value) int // nil for a hash index
+
+	hash map[interface{}]*bucket
+
+	// sorted holds the buckets of an ordered index, sorted by key. When Get
+	// empties a bucket, the bucket stays until more than half of the buckets
+	// are empty, and then they are removed all at once.
+	sorted []*bucket
+	empty  int
+}
+
+// A bucket holds the sequence numbers of the elements with the same field
+// value, in ascending order.
+type bucket struct {
+	key  reflect.Value // a copy of the field value
+	seqs []int
+}
+
+// NewIndexedCabinet creates a cabinet of elements of type t without any
+// indexes yet.
+func NewIndexedCabinet(t reflect.Type) *IndexedCabinet {
+	return &IndexedCabinet{Cabinet: *NewCabinet(t)}
+}
+
+// CreateIndex creates an index on a field of the elements, which must be
+// structs or pointers to structs. The field is named as in SortBy. For
+// fields that SortBy can sort by, the index is ordered and supports both
+// Lookup and Range; for fields of other comparable types (but not interface
+// types), it is a hash index that supports Lookup only. The field must be
+// exported. Creating an index that already exists does nothing.
+//
+// The index only sees changes made through the cabinet. Modifying the
+// elements through the slice that Slice returns invalidates it.
+func (c *IndexedCabinet) CreateIndex(field string) error {
+	if c.fields[field] != nil {
+		return nil
+	}
+	p, cmp, err := indexPath("CreateIndex", c.elemType(), field)
+	if err != nil {
+		return err
+	}
+	if c.fields == nil {
+		c.fields = map[string]*fieldIndex{}
+	}
+	fi := &fieldIndex{path: p, cmp: cmp}
+	fi.build(c)
+	c.fields[field] = fi
+	return nil
+}
+
+// Put appends val to the cabinet, as Cabinet.Put does, and adds it to the
+// indexes.
+func (c *IndexedCabinet) Put(val interface{}) error {
+	if err := c.Cabinet.Put(val); err != nil {
+		return err
+	}
+	c.indexLast()
+	return nil
+}
+
+// PutConverted appends val to the cabinet, as Cabinet.PutConverted does,
+// and adds it to the indexes.
+func (c *IndexedCabinet) PutConverted(val interface{}) error {
+	if err := c.Cabinet.PutConverted(val); err != nil {
+		return err
+	}
+	c.indexLast()
+	return nil
+}
+
+// indexLast adds the last element to the indexes.
+func (c *IndexedCabinet) indexLast() {
+	i := c.Len() - 1
+	for _, fi := range c.fields {
+		fi.add(c.s.Index(i), c.off+i)
+	}
+}
+
+// Get removes the first element, as Cabinet.Get does, and removes it from
+// the indexes.
+func (c *IndexedCabinet) Get(retref interface{}) error {
+	if c.Len() == 0 {
+		return c.Cabinet.Get(retref)
+	}
+	// Get leaves the element in the backing array, so first stays valid.
+	first := c.s.Index(0)
+	if err := c.Cabinet.Get(retref); err != nil {
+		return err
+	}
+	for _, fi := range c.fields {
+		fi.remove(first)
+	}
+	c.off++
+	return nil
+}
+
+// SortBy sorts the elements, as Cabinet.SortBy does, and rebuilds the
+// indexes.
+func (c *IndexedCabinet) SortBy(keys ...string) error {
+	if err := c.Cabinet.SortBy(keys...); err != nil {
+		return err
+	}
+	c.off = 0
+	for _, fi := range c.fields {
+		*fi = fieldIndex{path: fi.path, cmp: fi.cmp}
+		fi.build(c)
+	}
+	return nil
+}
+
+// Lookup returns a new cabinet with the elements whose field has the given
+// value. Numeric values are converted to the type of the field, so that,
+// for example, an int works for a uint16 field. The result keeps the order
+// of the elements. A field that holds a value that cannot be compared, such
+// as a slice in an interface field, matches no value.
+func (c *Cabinet) Lookup(field string, value interface{}) (*Cabinet, error) {
+	p, cmp, err := indexPath("Lookup", c.elemType(), field)
+	if err != nil {
+		return nil, err
+	}
+	key, err := lookupKey(p.typ, field, value)
+	if err != nil {
+		return nil, err
+	}
+	if cmp != nil {
+		return c.scanRange(p, cmp, key, key), nil
+	}
+	var pos []int
+	for i := 0; i < c.Len(); i++ {
+		if f, ok := p.get(c.s.Index(i)); ok && f.Comparable() && f.Equal(key) {
+			pos = append(pos, i)
+		}
+	}
+	return c.subset(pos), nil
+}
+
+// Lookup returns the elements whose field has the given value, as
+// Cabinet.Lookup does. With an index on the field, it does not need to scan
+// all elements.
+func (c *IndexedCabinet) Lookup(field string, value interface{}) (*Cabinet, error) {
+	fi := c.fields[field]
+	if fi == nil {
+		return c.Cabinet.Lookup(field, value)
+	}
+	key, err := lookupKey(fi.path.typ, field, value)
+	if err != nil {
+		return nil, err
+	}
+	if fi.cmp != nil {
+		return c.rangeOf(fi, key, key), nil
+	}
+	var seqs []int
+	if b := fi.hash[key.Interface()]; b != nil {
+		seqs = b.seqs
+	}
+	return c.elements(seqs), nil
+}
+
+// Range returns a new cabinet with the elements whose field lies between lo
+// and hi, inclusively. The field must be of a type that SortBy can sort by.
+// A NaN lies in no range. The result keeps the order of the elements.
+func (c *Cabinet) Range(field string, lo, hi interface{}) (*Cabinet, error) {
+	p, cmp, err := indexPath("Range", c.elemType(), field)
+	if err != nil {
+		return nil, err
+	}
+	if cmp == nil {
+		return nil, fmt.Errorf("Range: values of field %s of type %s are not ordered: %w", field, p.typ, ErrWrongType)
+	}
+	lv, hv, err := rangeKeys(p.typ, field, lo, hi)
+	if err != nil {
+		return nil, err
+	}
+	return c.scanRange(p, cmp, lv, hv), nil
+}
+
+// Range returns the elements whose field lies between lo and hi, as
+// Cabinet.Range does. With an index on the field, it does not need to scan
+// all elements.
+func (c *IndexedCabinet) Range(field string, lo, hi interface{}) (*Cabinet, error) {
+	fi := c.fields[field]
+	if fi == nil || fi.cmp == nil {
+		return c.Cabinet.Range(field, lo, hi)
+	}
+	lv, hv, err := rangeKeys(fi.path.typ, field, lo, hi)
+	if err != nil {
+		return nil, err
+	}
+	return c.rangeOf(fi, lv, hv), nil
+}
+
+// scanRange returns the elements whose field lies between lo and hi,
+// scanning all elements.
+func (c *Cabinet) scanRange(p path, cmp func(a, b reflect.Value) int, lo, hi reflect.Value) *Cabinet {
+	var pos []int
+	if isNaN(lo) || isNaN(hi) {
+		return c.subset(pos)
+	}
+	for i := 0; i < c.Len(); i++ {
+		if f, ok := p.get(c.s.Index(i)); ok && !isNaN(f) && cmp(f, lo) >= 0 && cmp(f, hi) <= 0 {
+			pos = append(pos, i)
+		}
+	}
+	return c.subset(pos)
+}
+
+// rangeOf returns the elements whose field lies between lo and hi, using
+// the ordered index fi.
+func (c *IndexedCabinet) rangeOf(fi *fieldIndex, lo, hi reflect.Value) *Cabinet {
+	var seqs []int
+	if isNaN(lo) || isNaN(hi) {
+		return c.elements(seqs)
+	}
+	i := sort.Search(len(fi.sorted), func(i int) bool { return fi.cmp(fi.sorted[i].key, lo) >= 0 })
+	for ; i < len(fi.sorted) && fi.cmp(fi.sorted[i].key, hi) <= 0; i++ {
+		seqs = append(seqs, fi.sorted[i].seqs...)
+	}
+	sort.Ints(seqs)
+	return c.elements(seqs)
+}
+
+// indexPath resolves a field of elements of type t for op and checks that
+// its type can be indexed. It returns a comparer if the type is ordered.
+func indexPath(op string, t reflect.Type, field string) (path, func(a, b reflect.Value) int, error) {
+	p, err := fieldPath(t, field)
+	if err != nil {
+		return path{}, nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if p.unexported {
+		return path{}, nil, fmt.Errorf("%s: field %s is not exported: %w", op, field, ErrWrongType)
+	}
+	if cmp, err := comparer(p.typ); err == nil {
+		return p, cmp, nil
+	}
+	if !p.typ.Comparable() || p.typ.Kind() == reflect.Interface {
+		return path{}, nil, fmt.Errorf("%s: values of field %s of type %s are neither ordered nor comparable: %w", op, field, p.typ, ErrWrongType)
+	}
+	return p, nil, nil
+}
+
+// lookupKey returns the value that Lookup looks for in a field of type t.
+func lookupKey(t reflect.Type, field string, value interface{}) (reflect.Value, error) {
+	key, err := indexKey(t, value)
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("Lookup: field %s: %w", field, err)
+	}
+	if !key.Comparable() {
+		return reflect.Value{}, fmt.Errorf("Lookup: field %s: %v holds a value that cannot be compared: %w", field, value, ErrWrongType)
+	}
+	return key, nil
+}
+
+// rangeKeys returns the bounds of Range in a field of type t.
+func rangeKeys(t reflect.Type, field string, lo, hi interface{}) (reflect.Value, reflect.Value, error) {
+	lv, err := indexKey(t, lo)
+	if err != nil {
+		return reflect.Value{}, reflect.Value{}, fmt.Errorf("Range: field %s: %w", field, err)
+	}
+	hv, err := indexKey(t, hi)
+	if err != nil {
+		return reflect.Value{}, reflect.Value{}, fmt.Errorf("Range: field %s: %w", field, err)
+	}
+	return lv, hv, nil
+}
+
+// indexKey returns value as a value of type t, converting numbers if
+// needed.
+func indexKey(t reflect.Type, value interface{}) (reflect.Value, error) {
+	key := reflect.New(t).Elem()
+	if value == nil {
+		if !canBeNil(t.Kind()) {
+			return reflect.Value{}, fmt.Errorf("cannot compare a %s with nil: %w", t, ErrWrongType)
+		}
+		return key, nil
+	}
+	v := reflect.ValueOf(value)
+	switch {
+	case v.Type().AssignableTo(t):
+		key.Set(v)
+	case isNumber(v.Kind()) && isNumber(t.Kind()):
+		cv, ok := convertNumber(v, t)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("cannot convert %v (%s) to %s without changing its value: %w", value, v.Type(), t, ErrWrongType)
+		}
+		key.Set(cv)
+	default:
+		return reflect.Value{}, fmt.Errorf("cannot compare a %s with a %s: %w", t, v.Type(), ErrWrongType)
+	}
+	return key, nil
+}
+
+// subset returns a new cabinet with the elements at the given positions.
+func (c *Cabinet) subset(pos []int) *Cabinet {
+	res := &Cabinet{s: reflect.MakeSlice(c.s.Type(), 0, len(pos))}
+	for _, i := range pos {
+		res.s = reflect.Append(res.s, c.s.Index(i))
+	}
+	return res
+}
+
+// elements returns a new cabinet with the elements of the given sequence
+// numbers.
+func (c *IndexedCabinet) elements(seqs []int) *Cabinet {
+	pos := make([]int, len(seqs))
+	for i, seq := range seqs {
+		pos[i] = seq - c.off
+	}
+	return c.subset(pos)
+}
+
+// build adds the elements of c to the empty index.
+func (fi *fieldIndex) build(c *IndexedCabinet) {
+	fi.hash = map[interface{}]*bucket{}
+	for i := 0; i < c.Len(); i++ {
+		fi.add(c.s.Index(i), c.off+i)
+	}
+}
+
+// add adds the element v with sequence number seq, which must be higher
+// than all sequence numbers in the index. Elements with a nil pointer on
+// the way to the field are not indexed, and neither are elements whose
+// field is or contains a NaN, which equals no value, not even itself, or
+// holds a value that cannot be compared, such as a slice in an interface
+// field.
+func (fi *fieldIndex) add(v reflect.Value, seq int) {
+	k, ok := fi.key(v)
+	if !ok {
+		return
+	}
+	b := fi.hash[k]
+	if b == nil {
+		b = &bucket{key: reflect.New(fi.path.typ).Elem()}
+		b.key.Set(reflect.ValueOf(k))
+		fi.hash[k] = b
+		if fi.cmp != nil {
+			i := sort.Search(len(fi.sorted), func(i int) bool { return fi.cmp(fi.sorted[i].key, b.key) > 0 })
+			fi.sorted = append(fi.sorted, nil)
+			copy(fi.sorted[i+1:], fi.sorted[i:])
+			fi.sorted[i] = b
+		}
+	} else if len(b.seqs) == 0 {
+		fi.empty--
+	}
+	b.seqs = append(b.seqs, seq)
+}
+
+// key returns the value of the indexed field of v as a map key. It returns
+// false if v is not indexed.
+func (fi *fieldIndex) key(v reflect.Value) (interface{}, bool) {
+	f, ok := fi.path.get(v)
+	if !ok {
+		return nil, false
+	}
+	if !f.Comparable() {
+		return nil, false
+	}
+	k := f.Interface()
+	return k, k == k
+}
+
+// isNaN reports whether v is a floating-point NaN.
+func isNaN(v reflect.Value) bool {
+	k := v.Kind()
+	return (k == reflect.Float32 || k == reflect.Float64) && v.Float() != v.Float()
+}
+
+// remove removes the element v, which must have the lowest sequence number
+// in the index.
+func (fi *fieldIndex) remove(v reflect.Value) {
+	k, ok := fi.key(v)
+	if !ok {
+		return
+	}
+	b := fi.hash[k]
+	if b == nil {
+		return // cannot happen as long as the index is up to date
+	}
+	if b.seqs = b.seqs[1:]; len(b.seqs) > 0 {
+		return
+	}
+	b.seqs = nil
+	if fi.cmp == nil {
+		delete(fi.hash, k)
+		return
+	}
+	fi.empty++
+	if fi.empty <= len(fi.sorted)/2 {
+		return
+	}
+	live := fi.sorted[:0]
+	for _, b := range fi.sorted {
+		if len(b.seqs) > 0 {
+			live = append(live, b)
+		} else {
+			delete(fi.hash, b.key.Interface())
+		}
+	}
+	clear(fi.sorted[len(live):]) // do not keep the buckets alive
+	fi.sorted = live
+	fi.empty = 0
+}
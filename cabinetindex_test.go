@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type scored struct {
+	Name  string
+	Score float64
+	when  time.Time
+}
+
+// A finder is a Cabinet or an IndexedCabinet.
+type finder interface {
+	Lookup(field string, value interface{}) (*Cabinet, error)
+	Range(field string, lo, hi interface{}) (*Cabinet, error)
+}
+
+// names returns the names of the elements of a cabinet of scored.
+func names(t *testing.T, c *Cabinet) []string {
+	t.Helper()
+	var res []string
+	for _, e := range c.Slice().([]scored) {
+		res = append(res, e.Name)
+	}
+	return res
+}
+
+func TestIndexNaN(t *testing.T) {
+	elems := []scored{
+		{Name: "a", Score: 1},
+		{Name: "nan", Score: math.NaN()},
+		{Name: "b", Score: 2},
+		{Name: "nan2", Score: math.NaN()},
+		{Name: "c", Score: 1},
+	}
+	plain := NewCabinet(reflect.TypeOf(scored{}))
+	indexed := NewIndexedCabinet(reflect.TypeOf(scored{}))
+	for _, e := range elems {
+		plain.Put(e)
+		indexed.Put(e)
+	}
+	if err := indexed.CreateIndex("Score"); err != nil {
+		t.Fatal(err)
+	}
+	// Drain both cabinets and compare the results on the way.
+	for plain.Len() > 0 {
+		for _, c := range []finder{plain, indexed} {
+			res, err := c.Range("Score", math.Inf(-1), math.Inf(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range names(t, res) {
+				if name == "nan" || name == "nan2" {
+					t.Errorf("Range over all numbers contains %s", name)
+				}
+			}
+			if res, _ := c.Lookup("Score", math.NaN()); res.Len() != 0 {
+				t.Errorf("Lookup(Score, NaN) = %v, want nothing", names(t, res))
+			}
+		}
+		got, err := indexed.Lookup("Score", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := plain.Lookup("Score", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names(t, got), names(t, want)) {
+			t.Errorf("Lookup(Score, 1) = %v, want %v", names(t, got), names(t, want))
+		}
+		var e scored
+		if err := plain.Get(&e); err != nil {
+			t.Fatal(err)
+		}
+		if err := indexed.Get(&e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIndexUnexported(t *testing.T) {
+	c := NewIndexedCabinet(reflect.TypeOf(scored{}))
+	c.Put(scored{Name: "a"})
+	if err := c.CreateIndex("when"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("CreateIndex(when) = %v, want ErrWrongType", err)
+	}
+	if _, err := c.Lookup("when", time.Time{}); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Lookup(when) = %v, want ErrWrongType", err)
+	}
+	if _, err := c.Range("when", time.Time{}, time.Now()); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Range(when) = %v, want ErrWrongType", err)
+	}
+}
+
+type tagged struct {
+	Name string
+	Tag  struct{ X interface{} }
+}
+
+func TestIndexUncomparable(t *testing.T) {
+	var a, b, s tagged
+	a.Name, a.Tag.X = "a", 1
+	b.Name, b.Tag.X = "b", "x"
+	s.Name, s.Tag.X = "s", []int{1}
+	for _, indexed := range []bool{false, true} {
+		c := NewIndexedCabinet(reflect.TypeOf(tagged{}))
+		c.Put(a)
+		c.Put(s)
+		if indexed {
+			if err := c.CreateIndex("Tag"); err != nil {
+				t.Fatalf("CreateIndex = %v", err)
+			}
+		}
+		if err := c.Put(s); err != nil {
+			t.Fatalf("Put = %v", err)
+		}
+		if err := c.Put(b); err != nil {
+			t.Fatalf("Put = %v", err)
+		}
+		res, err := c.Lookup("Tag", b.Tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Slice().([]tagged); len(got) != 1 || got[0].Name != "b" {
+			t.Errorf("indexed %v: Lookup(Tag, %v) = %v, want b", indexed, b.Tag, got)
+		}
+		if _, err := c.Lookup("Tag", s.Tag); !errors.Is(err, ErrWrongType) {
+			t.Errorf("indexed %v: Lookup(Tag, %v) = %v, want ErrWrongType", indexed, s.Tag, err)
+		}
+		for c.Len() > 0 {
+			var e tagged
+			if err := c.Get(&e); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestIndexUpkeep(t *testing.T) {
+	plain := NewCabinet(reflect.TypeOf(scored{}))
+	indexed := NewIndexedCabinet(reflect.TypeOf(scored{}))
+	if err := indexed.CreateIndex("Score"); err != nil {
+		t.Fatal(err)
+	}
+	if err := indexed.CreateIndex("Name"); err != nil {
+		t.Fatal(err)
+	}
+	check := func(when string) {
+		t.Helper()
+		for _, q := range []struct {
+			field string
+			value interface{}
+		}{{"Score", 2}, {"Score", 3.5}, {"Name", "b"}} {
+			got, err := indexed.Lookup(q.field, q.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := plain.Lookup(q.field, q.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(names(t, got), names(t, want)) {
+				t.Errorf("%s: Lookup(%s, %v) = %v, want %v", when, q.field, q.value, names(t, got), names(t, want))
+			}
+		}
+		got, err := indexed.Range("Score", 1, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := plain.Range("Score", 1, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names(t, got), names(t, want)) {
+			t.Errorf("%s: Range(Score, 1, 3) = %v, want %v", when, names(t, got), names(t, want))
+		}
+	}
+	for i, name := range []string{"a", "b", "c", "b", "d", "e", "b"} {
+		e := scored{Name: name, Score: float64(i%3) + 1}
+		plain.Put(e)
+		indexed.Put(e)
+	}
+	if err := indexed.PutConverted(scored{Name: "f", Score: 3.5}); err != nil {
+		t.Fatal(err)
+	}
+	plain.Put(scored{Name: "f", Score: 3.5})
+	check("after Put")
+	var e scored
+	for i := 0; i < 2; i++ {
+		plain.Get(&e)
+		indexed.Get(&e)
+	}
+	check("after Get")
+	if err := plain.SortBy("-Score", "Name"); err != nil {
+		t.Fatal(err)
+	}
+	if err := indexed.SortBy("-Score", "Name"); err != nil {
+		t.Fatal(err)
+	}
+	check("after SortBy")
+	plain.Get(&e)
+	indexed.Get(&e)
+	check("after SortBy and Get")
+}
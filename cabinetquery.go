@@ -0,0 +1,384 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A Query is a filter expression over the fields of structs, as in
+//
+//	Age >= 30 && Name ~ "^Ch"
+//
+// A comparison has a field on the left and a literal on the right. Fields
+// are named as in SortBy, including dotted paths like Address.City.
+// Literals are numbers, Go string literals, true, and false. The operators
+// are ==, !=, <, <=, >, >=, and ~ and !~, which match a string field
+// against a regular expression. A boolean field can stand alone, as in
+// `Active && !Deleted`. Comparisons combine with && (which binds stronger),
+// ||, !, and parentheses.
+//
+// A field behind a nil pointer matches no comparison.
+//
+// ParseQuery checks the syntax of a query. Whether the fields exist and fit
+// the literals can only be checked against a given type; this happens when
+// the query gets compiled for a cabinet, before any element is looked at.
+type Query struct {
+	src  string
+	expr expr
+}
+
+// A QueryError reports a syntax or type error in a query. Type errors wrap
+// ErrWrongType.
+type QueryError struct {
+	Query string
+	Pos   int // byte offset in Query
+	Msg   string
+	err   error
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("query %q: offset %d: %s", e.Query, e.Pos, e.Msg)
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.err
+}
+
+// ParseQuery parses a query.
+func ParseQuery(query string) (*Query, error) {
+	p := &queryParser{src: query}
+	p.next()
+	e := p.parseOr()
+	if p.err == nil && p.tok.kind != tokEOF {
+		p.fail(p.tok.pos, "unexpected %s", p.tok)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &Query{src: query, expr: e}, nil
+}
+
+// String returns the source of the query.
+func (q *Query) String() string {
+	return q.src
+}
+
+// Where returns a new cabinet with the elements that match the query. See
+// Query for the syntax.
+func (c *Cabinet) Where(query string) (*Cabinet, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("Where: %w", err)
+	}
+	return c.WhereQuery(q)
+}
+
+// WhereQuery is like Where but takes a parsed query, which can be reused
+// across cabinets.
+func (c *Cabinet) WhereQuery(q *Query) (*Cabinet, error) {
+	match, err := q.expr.compile(c.elemType(), q.src)
+	if err != nil {
+		return nil, fmt.Errorf("Where: %w", err)
+	}
+	res := &Cabinet{s: reflect.MakeSlice(c.s.Type(), 0, 10)}
+	for i := 0; i < c.Len(); i++ {
+		if v := c.s.Index(i); match(v) {
+			res.s = reflect.Append(res.s, v)
+		}
+	}
+	return res, nil
+}
+
+// The syntax tree of a query consists of exprs.
+type expr interface {
+	// compile turns the expression into a predicate for values of type t.
+	compile(t reflect.Type, src string) (func(reflect.Value) bool, error)
+}
+
+type (
+	binaryExpr struct {
+		op   string // "&&" or "||"
+		x, y expr
+	}
+	notExpr struct {
+		x expr
+	}
+	cmpExpr struct {
+		field token
+		op    token // zero for a standalone boolean field
+		lit   token
+	}
+)
+
+func (e *binaryExpr) compile(t reflect.Type, src string) (func(reflect.Value) bool, error) {
+	x, err := e.x.compile(t, src)
+	if err != nil {
+		return nil, err
+	}
+	y, err := e.y.compile(t, src)
+	if err != nil {
+		return nil, err
+	}
+	if e.op == "&&" {
+		return func(v reflect.Value) bool { return x(v) && y(v) }, nil
+	}
+	return func(v reflect.Value) bool { return x(v) || y(v) }, nil
+}
+
+func (e *notExpr) compile(t reflect.Type, src string) (func(reflect.Value) bool, error) {
+	x, err := e.x.compile(t, src)
+	if err != nil {
+		return nil, err
+	}
+	return func(v reflect.Value) bool { return !x(v) }, nil
+}
+
+func (e *cmpExpr) compile(t reflect.Type, src string) (func(reflect.Value) bool, error) {
+	fail := func(tok token, format string, args ...interface{}) (func(reflect.Value) bool, error) {
+		return nil, &QueryError{src, tok.pos, fmt.Sprintf(format, args...), ErrWrongType}
+	}
+	p, err := fieldPath(t, e.field.text)
+	if err != nil {
+		// The QueryError wraps ErrWrongType itself.
+		return fail(e.field, "%s", strings.TrimSuffix(err.Error(), ": "+ErrWrongType.Error()))
+	}
+	op, lit := e.op.text, e.lit
+	if e.op.kind == tokEOF {
+		// A standalone field must be a boolean.
+		if p.typ.Kind() != reflect.Bool {
+			return fail(e.field, "%s field %s needs a comparison, as it is not a bool", p.typ, e.field.text)
+		}
+		op, lit = "==", token{kind: tokIdent, text: "true", pos: e.field.pos}
+	}
+
+	var cmp func(f reflect.Value) int // compares the field with the literal
+	kind := p.typ.Kind()
+	switch {
+	case op == "~" || op == "!~":
+		if kind != reflect.String || lit.kind != tokString {
+			return fail(e.op, "%s needs a string field and a string literal", op)
+		}
+		re, err := regexp.Compile(lit.value)
+		if err != nil {
+			return fail(lit, "%v", err)
+		}
+		want := op == "~"
+		return func(v reflect.Value) bool {
+			f, ok := p.get(v)
+			return ok && re.MatchString(f.String()) == want
+		}, nil
+	case kind == reflect.Bool:
+		if lit.kind != tokIdent || (lit.text != "true" && lit.text != "false") {
+			return fail(lit, "cannot compare bool field %s with %s", e.field.text, lit.text)
+		}
+		if op != "==" && op != "!=" {
+			return fail(e.op, "bool field %s does not support %s", e.field.text, op)
+		}
+		b := lit.text == "true"
+		cmp = func(f reflect.Value) int { return boolCmp(f.Bool(), b) }
+	case kind >= reflect.Int && kind <= reflect.Int64:
+		n, err := strconv.ParseInt(lit.text, 0, 64)
+		if lit.kind != tokNumber || err != nil || reflect.Zero(p.typ).OverflowInt(n) {
+			return fail(lit, "cannot compare %s field %s with %s", p.typ, e.field.text, lit.text)
+		}
+		cmp = func(f reflect.Value) int { return ordCmp(f.Int(), n) }
+	case kind >= reflect.Uint && kind <= reflect.Uintptr:
+		n, err := strconv.ParseUint(lit.text, 0, 64)
+		if lit.kind != tokNumber || err != nil || reflect.Zero(p.typ).OverflowUint(n) {
+			return fail(lit, "cannot compare %s field %s with %s", p.typ, e.field.text, lit.text)
+		}
+		cmp = func(f reflect.Value) int { return ordCmp(f.Uint(), n) }
+	case kind == reflect.Float32 || kind == reflect.Float64:
+		x, err := strconv.ParseFloat(lit.text, 64)
+		if lit.kind != tokNumber || err != nil {
+			return fail(lit, "cannot compare %s field %s with %s", p.typ, e.field.text, lit.text)
+		}
+		cmp = func(f reflect.Value) int { return ordCmp(f.Float(), x) }
+	case kind == reflect.String:
+		if lit.kind != tokString {
+			return fail(lit, "cannot compare string field %s with %s", e.field.text, lit.text)
+		}
+		s := lit.value
+		cmp = func(f reflect.Value) int { return strings.Compare(f.String(), s) }
+	default:
+		return fail(e.field, "field %s of type %s cannot be compared", e.field.text, p.typ)
+	}
+
+	var test func(r int) bool
+	switch op {
+	case "==":
+		test = func(r int) bool { return r == 0 }
+	case "!=":
+		test = func(r int) bool { return r != 0 }
+	case "<":
+		test = func(r int) bool { return r < 0 }
+	case "<=":
+		test = func(r int) bool { return r <= 0 }
+	case ">":
+		test = func(r int) bool { return r > 0 }
+	case ">=":
+		test = func(r int) bool { return r >= 0 }
+	}
+	return func(v reflect.Value) bool {
+		f, ok := p.get(v)
+		return ok && test(cmp(f))
+	}, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value string // the unquoted value of a string literal
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of query"
+	}
+	return strconv.Quote(t.text)
+}
+
+// queryParser is a recursive descent parser for queries.
+type queryParser struct {
+	src string
+	off int   // offset of the next token
+	tok token // current token
+	err error // first error
+}
+
+func (p *queryParser) fail(pos int, format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = &QueryError{p.src, pos, fmt.Sprintf(format, args...), nil}
+	}
+	p.tok = token{kind: tokEOF, pos: len(p.src)}
+	p.off = len(p.src)
+}
+
+// next scans the next token.
+func (p *queryParser) next() {
+	for p.off < len(p.src) && unicode.IsSpace(rune(p.src[p.off])) {
+		p.off++
+	}
+	start := p.off
+	if start == len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+	rest := p.src[start:]
+	switch c := rest[0]; {
+	case c == '_' || unicode.IsLetter(rune(c)):
+		n := strings.IndexFunc(rest, func(r rune) bool { return r != '_' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+		if n < 0 {
+			n = len(rest)
+		}
+		p.tok = token{kind: tokIdent, text: rest[:n], pos: start}
+	case c == '-' || c == '+' || c == '.' || unicode.IsDigit(rune(c)):
+		n := strings.IndexFunc(rest[1:], func(r rune) bool { return !strings.ContainsRune("0123456789abcdefABCDEFxXoO_.+-", r) })
+		if n < 0 {
+			n = len(rest) - 1
+		}
+		p.tok = token{kind: tokNumber, text: rest[:n+1], pos: start}
+	case c == '"' || c == '`':
+		q, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			p.fail(start, "unterminated string literal")
+			return
+		}
+		v, _ := strconv.Unquote(q)
+		p.tok = token{kind: tokString, text: q, value: v, pos: start}
+	default:
+		for _, op := range []string{"&&", "||", "==", "!=", "<=", ">=", "!~", "<", ">", "~", "!", "(", ")"} {
+			if strings.HasPrefix(rest, op) {
+				p.tok = token{kind: tokOp, text: op, pos: start}
+				p.off += len(op)
+				return
+			}
+		}
+		p.fail(start, "unexpected character %q", c)
+		return
+	}
+	p.off += len(p.tok.text)
+}
+
+func (p *queryParser) isOp(ops ...string) bool {
+	if p.tok.kind != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if p.tok.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *queryParser) parseOr() expr {
+	x := p.parseAnd()
+	for p.isOp("||") {
+		p.next()
+		x = &binaryExpr{"||", x, p.parseAnd()}
+	}
+	return x
+}
+
+func (p *queryParser) parseAnd() expr {
+	x := p.parseUnary()
+	for p.isOp("&&") {
+		p.next()
+		x = &binaryExpr{"&&", x, p.parseUnary()}
+	}
+	return x
+}
+
+func (p *queryParser) parseUnary() expr {
+	switch {
+	case p.isOp("!"):
+		p.next()
+		return &notExpr{p.parseUnary()}
+	case p.isOp("("):
+		p.next()
+		x := p.parseOr()
+		if !p.isOp(")") {
+			p.fail(p.tok.pos, "expected \")\", found %s", p.tok)
+		}
+		p.next()
+		return x
+	}
+	return p.parseComparison()
+}
+
+func (p *queryParser) parseComparison() expr {
+	if p.tok.kind != tokIdent || p.tok.text == "true" || p.tok.text == "false" {
+		p.fail(p.tok.pos, "expected a field name, found %s", p.tok)
+		return nil
+	}
+	e := &cmpExpr{field: p.tok}
+	p.next()
+	if !p.isOp("==", "!=", "<", "<=", ">", ">=", "~", "!~") {
+		return e // a standalone boolean field
+	}
+	e.op = p.tok
+	p.next()
+	if p.tok.kind == tokEOF || p.tok.kind == tokOp || p.tok.kind == tokIdent && p.tok.text != "true" && p.tok.text != "false" {
+		p.fail(p.tok.pos, "expected a literal, found %s", p.tok)
+		return nil
+	}
+	e.lit = p.tok
+	p.next()
+	return e
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type address struct {
+	City string
+}
+
+type person struct {
+	Name    string
+	Age     int
+	Small   uint8
+	Score   float64
+	Active  bool
+	Address *address
+}
+
+var people = []person{
+	{Name: "Alice", Age: 30, Small: 1, Score: 1.5, Active: true, Address: &address{"Berlin"}},
+	{Name: "Bob", Age: 25, Small: 2, Score: 2.5},
+	{Name: "Carol", Age: 35, Small: 3, Score: 0.5, Active: true, Address: &address{"Boston"}},
+	{Name: "Chris", Age: 40, Small: 255, Score: 3, Address: &address{"Berlin"}},
+}
+
+func TestWhere(t *testing.T) {
+	c, err := NewCabinetFromSlice(people)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`Age >= 30`, "Alice Carol Chris"},
+		{`Age == 0x1e`, "Alice"},
+		{`Small == 255`, "Chris"},
+		{`Score > 1.5`, "Bob Chris"},
+		{`Name == "Bob"`, "Bob"},
+		{"Name == `Bob`", "Bob"},
+		{`Active`, "Alice Carol"},
+		{`!Active`, "Bob Chris"},
+		{`Active == false`, "Bob Chris"},
+		// && binds stronger than ||. The other way round, the result would
+		// be that of the next query.
+		{`Age < 30 || Age > 30 && Active`, "Bob Carol"},
+		{`(Age < 30 || Age > 30) && Active`, "Carol"},
+		{`!(Age < 30) && !(Age > 35)`, "Alice Carol"},
+		{`!!Active`, "Alice Carol"},
+		{`Name ~ "^C"`, "Carol Chris"},
+		{`Name !~ "^C"`, "Alice Bob"},
+		{`Name ~ "o" && Name !~ "^C"`, "Bob"},
+		// Bob has no address, so no comparison on it matches, but its
+		// negation does.
+		{`Address.City == "Berlin"`, "Alice Chris"},
+		{`Address.City != "Berlin"`, "Carol"},
+		{`!(Address.City == "Berlin")`, "Bob Carol"},
+		{`Address.City ~ ""`, "Alice Carol Chris"},
+	}
+	for _, tt := range tests {
+		res, err := c.Where(tt.query)
+		if err != nil {
+			t.Errorf("Where(%s): %v", tt.query, err)
+			continue
+		}
+		var got []string
+		for _, p := range res.Slice().([]person) {
+			got = append(got, p.Name)
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("Where(%s) = %v, want %s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestQuerySyntaxErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+		msg   string
+	}{
+		{`Age >=`, 6, "expected a literal, found end of query"},
+		{`Age > Name`, 6, `expected a literal, found "Name"`},
+		{`Age > 30 &&`, 11, "expected a field name, found end of query"},
+		{`Age > 30 && || Active`, 12, `expected a field name, found "||"`},
+		{`true == Active`, 0, `expected a field name, found "true"`},
+		{`(Age > 30`, 9, `expected ")", found end of query`},
+		{`Age > 30)`, 8, `unexpected ")"`},
+		{`Active Age`, 7, `unexpected "Age"`},
+		{`Name == "Bob`, 8, "unterminated string literal"},
+		{`Age # 3`, 4, `unexpected character '#'`},
+		{``, 0, "expected a field name, found end of query"},
+	}
+	for _, tt := range tests {
+		_, err := ParseQuery(tt.query)
+		var qe *QueryError
+		if !errors.As(err, &qe) {
+			t.Errorf("ParseQuery(%s) = %v, want a QueryError", tt.query, err)
+			continue
+		}
+		if qe.Pos != tt.pos || qe.Msg != tt.msg || qe.Query != tt.query {
+			t.Errorf("ParseQuery(%s) = offset %d: %s, want offset %d: %s", tt.query, qe.Pos, qe.Msg, tt.pos, tt.msg)
+		}
+		if errors.Is(err, ErrWrongType) {
+			t.Errorf("ParseQuery(%s) = %v, which wraps ErrWrongType", tt.query, err)
+		}
+	}
+}
+
+func TestQueryTypeErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+		msg   string // part of the message
+	}{
+		{`Nope == 1`, 0, "Nope"},
+		{`Active && Address.Nope == 1`, 10, "Nope"},
+		{`Active < true`, 7, "bool field Active does not support <"},
+		{`Active == 1`, 10, "cannot compare bool field Active with 1"},
+		{`Small == 256`, 9, "cannot compare uint8 field Small with 256"},
+		{`Small == -1`, 9, "cannot compare uint8 field Small with -1"},
+		{`Age == "30"`, 7, `cannot compare int field Age with "30"`},
+		{`Age == 1.5`, 7, "cannot compare int field Age with 1.5"},
+		{`Score == "x"`, 9, `cannot compare float64 field Score with "x"`},
+		{`Name == 3`, 8, "cannot compare string field Name with 3"},
+		{`Age ~ "3"`, 4, "~ needs a string field and a string literal"},
+		{`Name !~ 3`, 5, "!~ needs a string field and a string literal"},
+		{`Name ~ "("`, 7, "missing closing )"},
+		{`Address == 1`, 0, "field Address of type *main.address cannot be compared"},
+		{`Age`, 0, "int field Age needs a comparison, as it is not a bool"},
+		{`Active && !Address`, 11, "*main.address field Address needs a comparison"},
+	}
+	c := NewCabinet(reflect.TypeOf(person{}))
+	for _, tt := range tests {
+		_, err := c.Where(tt.query)
+		if !errors.Is(err, ErrWrongType) {
+			t.Errorf("Where(%s) = %v, want ErrWrongType", tt.query, err)
+			continue
+		}
+		var qe *QueryError
+		if !errors.As(err, &qe) {
+			t.Errorf("Where(%s) = %v, want a QueryError", tt.query, err)
+			continue
+		}
+		if qe.Pos != tt.pos || !strings.Contains(qe.Msg, tt.msg) {
+			t.Errorf("Where(%s) = offset %d: %s, want offset %d: ...%s...", tt.query, qe.Pos, qe.Msg, tt.pos, tt.msg)
+		}
+		if strings.Contains(qe.Msg, "true") && !strings.Contains(tt.query, "true") {
+			t.Errorf("Where(%s) = %v, which mentions true", tt.query, err)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// SortBy sorts the elements of a cabinet of structs (or pointers to structs)
+// by the given fields. A key is a field name or a dotted path like
+// "Address.City"; fields of embedded structs can be addressed directly, as
+// in Go code. A key prefixed with "-" sorts in descending order. Later keys
+// decide between elements that are equal by the earlier keys, and elements
+// that are equal by all keys keep their order.
+//
+// Fields must be of a boolean, numeric, or string kind, or have a method
+// `Less(T) bool` or `Before(T) bool` (like time.Time). Unexported fields
+// must be of one of these kinds, as SortBy cannot call their methods. A nil
+// pointer on the way to a field sorts before all non-nil values.
+//
+// Without keys, SortBy uses the order that the element type defines, like
+// the sort package does: if the cabinet's slice type implements
+// sort.Interface (see NewCabinetFromSlice), it uses that; otherwise, the
+// element type must have a `Less(E) bool` method or be of an ordered kind.
+func (c *Cabinet) SortBy(keys ...string) error {
+	if len(keys) == 0 {
+		return c.sortNatural()
+	}
+	cmps := make([]func(a, b reflect.Value) int, len(keys))
+	for i, key := range keys {
+		desc := strings.HasPrefix(key, "-")
+		path, err := fieldPath(c.elemType(), strings.TrimPrefix(key, "-"))
+		if err != nil {
+			return fmt.Errorf("SortBy: %w", err)
+		}
+		cmp, err := comparer(path.typ)
+		if path.unexported {
+			// Methods cannot be called on values of unexported fields.
+			cmp, err = kindComparer(path.typ)
+			if err != nil && methodComparer(path.typ) != nil {
+				return fmt.Errorf("SortBy: field %s is not exported, so its %s cannot be compared with its methods: %w", key, path.typ, ErrWrongType)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("SortBy: field %s: %w", key, err)
+		}
+		cmps[i] = func(a, b reflect.Value) int {
+			fa, oka := path.get(a)
+			fb, okb := path.get(b)
+			r := 0
+			switch {
+			case !oka || !okb:
+				r = boolCmp(oka, okb)
+			default:
+				r = cmp(fa, fb)
+			}
+			if desc {
+				return -r
+			}
+			return r
+		}
+	}
+	sort.SliceStable(c.s.Interface(), func(i, j int) bool {
+		a, b := c.s.Index(i), c.s.Index(j)
+		for _, cmp := range cmps {
+			if r := cmp(a, b); r != 0 {
+				return r < 0
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+// sortNatural sorts the elements by the order that the types define.
+func (c *Cabinet) sortNatural() error {
+	if si, ok := c.s.Interface().(sort.Interface); ok {
+		sort.Stable(si)
+		return nil
+	}
+	cmp, err := comparer(c.elemType())
+	if err != nil {
+		return fmt.Errorf("SortBy: %w", err)
+	}
+	sort.SliceStable(c.s.Interface(), func(i, j int) bool {
+		return cmp(c.s.Index(i), c.s.Index(j)) < 0
+	})
+	return nil
+}
+
+// A path leads from a value to one of its (possibly nested) fields.
+type path struct {
+	index [][]int      // the field indexes for reflect.Value.FieldByIndex, one per path segment
+	typ   reflect.Type // type of the field
+	// unexported is true if the path passes through an unexported field, so
+	// that the field's value cannot be turned into an interface{}.
+	unexported bool
+}
+
+// fieldPath resolves a dotted field name, starting at type t.
+func fieldPath(t reflect.Type, name string) (path, error) {
+	var p path
+	for _, seg := range strings.Split(name, ".") {
+		st := t
+		if st.Kind() == reflect.Ptr {
+			st = st.Elem()
+		}
+		if st.Kind() != reflect.Struct {
+			return path{}, fmt.Errorf("cannot select field %s of %s in %s: %w", seg, t, name, ErrWrongType)
+		}
+		f, ok := st.FieldByName(seg)
+		if !ok {
+			return path{}, fmt.Errorf("%s has no field %s: %w", st, seg, ErrWrongType)
+		}
+		for i := range f.Index {
+			if st.FieldByIndex(f.Index[:i+1]).PkgPath != "" {
+				p.unexported = true
+			}
+		}
+		p.index = append(p.index, f.Index)
+		t = f.Type
+	}
+	p.typ = t
+	return p, nil
+}
+
+// get returns the field that p leads to in v. It returns false if there is a
+// nil pointer on the way.
+func (p path) get(v reflect.Value) (reflect.Value, bool) {
+	for _, index := range p.index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		var err error
+		v, err = v.FieldByIndexErr(index)
+		if err != nil {
+			return reflect.Value{}, false // nil pointer to an embedded struct
+		}
+	}
+	return v, true
+}
+
+// comparer returns a function that compares two values of type t and
+// returns -1, 0, or +1.
+func comparer(t reflect.Type) (func(a, b reflect.Value) int, error) {
+	if cmp := methodComparer(t); cmp != nil {
+		return cmp, nil
+	}
+	return kindComparer(t)
+}
+
+// methodComparer returns a comparer that uses the Less or Before method of
+// t, or nil if t has no such method.
+func methodComparer(t reflect.Type) func(a, b reflect.Value) int {
+	for _, name := range []string{"Less", "Before"} {
+		// The method may have a value or a pointer receiver. Elements and
+		// their fields are addressable, as they live in a slice.
+		for _, recv := range []reflect.Type{t, reflect.PointerTo(t)} {
+			m, ok := recv.MethodByName(name)
+			if !ok || m.Type.NumIn() != 2 || m.Type.In(1) != t || m.Type.NumOut() != 1 || m.Type.Out(0).Kind() != reflect.Bool {
+				continue
+			}
+			less := func(a, b reflect.Value) bool {
+				if recv != t {
+					a = a.Addr()
+				}
+				return a.Method(m.Index).Call([]reflect.Value{b})[0].Bool()
+			}
+			return func(a, b reflect.Value) int {
+				switch {
+				case less(a, b):
+					return -1
+				case less(b, a):
+					return 1
+				}
+				return 0
+			}
+		}
+	}
+	return nil
+}
+
+// kindComparer returns a comparer for the values of a boolean, numeric, or
+// string kind. Unlike methods, it also works on values obtained through
+// unexported fields.
+func kindComparer(t reflect.Type) (func(a, b reflect.Value) int, error) {
+	switch t.Kind() {
+	case reflect.Bool:
+		return func(a, b reflect.Value) int { return boolCmp(a.Bool(), b.Bool()) }, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(a, b reflect.Value) int { return ordCmp(a.Int(), b.Int()) }, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return func(a, b reflect.Value) int { return ordCmp(a.Uint(), b.Uint()) }, nil
+	case reflect.Float32, reflect.Float64:
+		return func(a, b reflect.Value) int { return ordCmp(a.Float(), b.Float()) }, nil
+	case reflect.String:
+		return func(a, b reflect.Value) int { return strings.Compare(a.String(), b.String()) }, nil
+	}
+	return nil, fmt.Errorf("values of type %s are not ordered: %w", t, ErrWrongType)
+}
+
+// boolCmp orders false before true.
+func boolCmp(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case b:
+		return -1
+	}
+	return 1
+}
+
+func ordCmp[T int64 | uint64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
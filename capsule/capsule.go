@@ -1,23 +1,870 @@
 package capsule
 
-import "github.com/cheekybits/genny/generic"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cheekybits/genny/generic"
+)
 
 type Item generic.Type
 
+// ItemCapsule stores its elements in a ring buffer that grows and shrinks
+// with the number of elements.
 type ItemCapsule struct {
-	s []Item
+	s    []Item
+	head int // index of the next element to Get
+	n    int // number of elements
 }
 
 func NewItemCapsule() *ItemCapsule {
-	return &ItemCapsule{s: []Item{}}
+	return &ItemCapsule{}
 }
 
 func (c *ItemCapsule) Put(val Item) {
-	c.s = append(c.s, val)
+	if c.n == len(c.s) {
+		c.resize(2 * c.n)
+	}
+	c.s[(c.head+c.n)%len(c.s)] = val
+	c.n++
 }
 
 func (c *ItemCapsule) Get() Item {
-	r := c.s[0]
-	c.s = c.s[1:]
+	if c.n == 0 {
+		panic("capsule: Get from empty capsule")
+	}
+	var zero Item
+	r := c.s[c.head]
+	c.s[c.head] = zero // do not keep the element alive
+	c.head = (c.head + 1) % len(c.s)
+	c.n--
+	if c.n <= len(c.s)/4 {
+		c.resize(len(c.s) / 2)
+	}
 	return r
 }
+
+func (c *ItemCapsule) Len() int {
+	return c.n
+}
+
+// ToChan returns a channel that a goroutine feeds with the elements of the
+// capsule, in the order of Get. The goroutine closes the channel and exits
+// when the capsule is empty or ctx is done, whatever comes first. It only
+// removes an element once the element has been received, so after a
+// cancellation, the capsule still holds the elements that nobody received.
+// The capsule must not be used by anyone else until the channel is closed.
+func (c *ItemCapsule) ToChan(ctx context.Context) <-chan Item {
+	ch := make(chan Item)
+	go func() {
+		defer close(ch)
+		for c.n > 0 {
+			select {
+			case ch <- c.s[c.head]:
+				c.Get()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// FromChan puts the elements received from ch into the capsule until ch is
+// closed, and returns nil then. If ctx is done first, FromChan returns
+// ctx.Err(). It does not start any goroutines.
+func (c *ItemCapsule) FromChan(ctx context.Context, ch <-chan Item) error {
+	for {
+		select {
+		case val, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			c.Put(val)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// resize moves the elements into a new ring buffer of the given size, which
+// must be at least c.n. The size never goes below 8.
+func (c *ItemCapsule) resize(size int) {
+	if size < 8 {
+		size = 8
+	}
+	if size == len(c.s) {
+		return
+	}
+	s := make([]Item, size)
+	k := copy(s, c.s[c.head:min(c.head+c.n, len(c.s))])
+	copy(s[k:], c.s[:c.n-k])
+	c.s = s
+	c.head = 0
+}
+
+// ItemBlockingCapsule is an ItemCapsule that is safe for concurrent use.
+// Get blocks until an element is available.
+type ItemBlockingCapsule struct {
+	mu      sync.Mutex
+	q       ItemCapsule
+	closed  bool
+	waiters int
+	// wake is closed and replaced on every Put and on Close to wake up all
+	// waiting Gets. Unlike a sync.Cond, a channel can be selected on together
+	// with a context.
+	wake chan struct{}
+}
+
+func NewItemBlockingCapsule() *ItemBlockingCapsule {
+	return &ItemBlockingCapsule{wake: make(chan struct{})}
+}
+
+// Put adds an element. It returns ErrClosed if the capsule is closed.
+func (c *ItemBlockingCapsule) Put(val Item) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	c.q.Put(val)
+	c.broadcast()
+	return nil
+}
+
+// Get removes and returns the next element, waiting for one if necessary.
+// It returns ctx.Err() if ctx is done before an element arrives, and
+// ErrClosed if the capsule is closed and empty.
+func (c *ItemBlockingCapsule) Get(ctx context.Context) (Item, error) {
+	c.mu.Lock()
+	for c.q.Len() == 0 {
+		if c.closed {
+			c.mu.Unlock()
+			var zero Item
+			return zero, ErrClosed
+		}
+		wake := c.wake
+		c.waiters++
+		c.mu.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			c.mu.Lock()
+			c.waiters--
+			c.mu.Unlock()
+			var zero Item
+			return zero, ctx.Err()
+		}
+		c.mu.Lock()
+		c.waiters--
+	}
+	r := c.q.Get()
+	c.mu.Unlock()
+	return r, nil
+}
+
+// TryGet removes and returns the next element if there is one. It never blocks.
+func (c *ItemBlockingCapsule) TryGet() (Item, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.q.Len() == 0 {
+		var zero Item
+		return zero, false
+	}
+	return c.q.Get(), true
+}
+
+func (c *ItemBlockingCapsule) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.q.Len()
+}
+
+// Close closes the capsule and wakes up all waiting Gets. Elements that are
+// still in the capsule can be retrieved after Close. Close is idempotent.
+func (c *ItemBlockingCapsule) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.broadcast()
+}
+
+// broadcast wakes up all waiting Gets. c.mu must be held.
+func (c *ItemBlockingCapsule) broadcast() {
+	if c.waiters > 0 || c.closed {
+		close(c.wake)
+		c.wake = make(chan struct{})
+	}
+}
+
+// BoundedItemCapsule is an ItemCapsule of limited capacity that is safe for
+// concurrent use. Its OverflowPolicy decides what Put does when the capsule
+// is full. Get blocks until an element is available.
+type BoundedItemCapsule struct {
+	mu      sync.Mutex
+	q       ItemCapsule
+	max     int
+	policy  OverflowPolicy
+	dropped uint64
+	closed  bool
+	waiters int
+	// wake is closed and replaced whenever an element comes or goes, and on
+	// Close, to wake up all waiting Puts and Gets.
+	wake chan struct{}
+}
+
+// NewBoundedItemCapsule creates a capsule that holds at most n elements,
+// with the given policy for a full capsule. It panics if n < 1.
+func NewBoundedItemCapsule(n int, policy OverflowPolicy) *BoundedItemCapsule {
+	if n < 1 {
+		panic("capsule: capacity of bounded capsule must be positive")
+	}
+	return &BoundedItemCapsule{max: n, policy: policy, wake: make(chan struct{})}
+}
+
+// Put adds an element. If the capsule is full, Put follows the policy of
+// the capsule: It waits for room (Block), returns ErrFull (Reject), or
+// discards the oldest element (DropOldest) or val (DropNewest). Waiting Puts
+// return ctx.Err() if ctx is done before there is room. Put returns
+// ErrClosed if the capsule is closed.
+func (c *BoundedItemCapsule) Put(ctx context.Context, val Item) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		if c.closed {
+			return ErrClosed
+		}
+		if c.q.Len() < c.max {
+			break
+		}
+		switch c.policy {
+		case Reject:
+			return ErrFull
+		case DropOldest:
+			c.q.Get()
+			c.dropped++
+		case DropNewest:
+			c.dropped++
+			return nil
+		default:
+			if err := c.wait(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	c.q.Put(val)
+	c.broadcast()
+	return nil
+}
+
+// Get removes and returns the next element, waiting for one if necessary.
+// It returns ctx.Err() if ctx is done before an element arrives, and
+// ErrClosed if the capsule is closed and empty.
+func (c *BoundedItemCapsule) Get(ctx context.Context) (Item, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.q.Len() == 0 {
+		if c.closed {
+			var zero Item
+			return zero, ErrClosed
+		}
+		if err := c.wait(ctx); err != nil {
+			var zero Item
+			return zero, err
+		}
+	}
+	r := c.q.Get()
+	c.broadcast()
+	return r, nil
+}
+
+// TryGet removes and returns the next element if there is one. It never blocks.
+func (c *BoundedItemCapsule) TryGet() (Item, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.q.Len() == 0 {
+		var zero Item
+		return zero, false
+	}
+	r := c.q.Get()
+	c.broadcast()
+	return r, true
+}
+
+func (c *BoundedItemCapsule) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.q.Len()
+}
+
+// Cap returns the capacity of the capsule.
+func (c *BoundedItemCapsule) Cap() int {
+	return c.max
+}
+
+// Dropped returns the number of elements that Put has discarded under the
+// DropOldest or DropNewest policy.
+func (c *BoundedItemCapsule) Dropped() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
+
+// Close closes the capsule and wakes up all waiting Puts and Gets. Elements
+// that are still in the capsule can be retrieved after Close. Close is
+// idempotent.
+func (c *BoundedItemCapsule) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.broadcast()
+}
+
+// wait waits until the next broadcast or until ctx is done. c.mu must be
+// held; wait releases it while waiting.
+func (c *BoundedItemCapsule) wait(ctx context.Context) error {
+	wake := c.wake
+	c.waiters++
+	c.mu.Unlock()
+	var err error
+	select {
+	case <-wake:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	c.mu.Lock()
+	c.waiters--
+	return err
+}
+
+// broadcast wakes up all waiting Puts and Gets. c.mu must be held.
+func (c *BoundedItemCapsule) broadcast() {
+	if c.waiters > 0 || c.closed {
+		close(c.wake)
+		c.wake = make(chan struct{})
+	}
+}
+
+// ItemMPMCCapsule is a lock-free capsule of fixed capacity for any number
+// of concurrent producers and consumers. It is a ring of slots, each with a
+// sequence number that tells whether the slot is ready for the Put or the
+// Get of a given round:
+//
+//   - seq == pos: the slot is empty and awaits the Put at position pos.
+//   - seq == pos+1: the slot holds the element for the Get at position pos.
+//
+// Producers and consumers claim positions by advancing tail or head with a
+// compare-and-swap, and then publish the slot for the other side by
+// advancing its sequence number.
+type ItemMPMCCapsule struct {
+	slots []itemMPMCSlot
+	mask  uint64
+	_     [64]byte      // keeps tail and head on cache lines of their own
+	tail  atomic.Uint64 // next position to Put to
+	_     [64]byte
+	head  atomic.Uint64 // next position to Get from
+	_     [64]byte
+}
+
+type itemMPMCSlot struct {
+	seq atomic.Uint64
+	val Item
+}
+
+// NewItemMPMCCapsule creates a capsule that holds at least n elements; the
+// capacity is rounded up to a power of two. It panics if n < 1.
+func NewItemMPMCCapsule(n int) *ItemMPMCCapsule {
+	if n < 1 {
+		panic("capsule: capacity of MPMC capsule must be positive")
+	}
+	size := 2
+	for size < n {
+		size *= 2
+	}
+	c := &ItemMPMCCapsule{slots: make([]itemMPMCSlot, size), mask: uint64(size - 1)}
+	for i := range c.slots {
+		c.slots[i].seq.Store(uint64(i))
+	}
+	return c
+}
+
+// TryPut adds an element if there is room, and reports whether it did so.
+// It never blocks.
+func (c *ItemMPMCCapsule) TryPut(val Item) bool {
+	pos := c.tail.Load()
+	for {
+		slot := &c.slots[pos&c.mask]
+		switch d := int64(slot.seq.Load() - pos); {
+		case d == 0:
+			if c.tail.CompareAndSwap(pos, pos+1) {
+				slot.val = val
+				slot.seq.Store(pos + 1)
+				return true
+			}
+		case d < 0:
+			// The slot still holds the element of the previous round.
+			return false
+		}
+		// Another producer got there first.
+		pos = c.tail.Load()
+	}
+}
+
+// TryGet removes and returns the next element if there is one. It never
+// blocks.
+func (c *ItemMPMCCapsule) TryGet() (Item, bool) {
+	pos := c.head.Load()
+	for {
+		slot := &c.slots[pos&c.mask]
+		switch d := int64(slot.seq.Load() - (pos + 1)); {
+		case d == 0:
+			if c.head.CompareAndSwap(pos, pos+1) {
+				var zero Item
+				r := slot.val
+				slot.val = zero // do not keep the element alive
+				slot.seq.Store(pos + c.mask + 1)
+				return r, true
+			}
+		case d < 0:
+			// The slot awaits its Put.
+			var zero Item
+			return zero, false
+		}
+		// Another consumer got there first.
+		pos = c.head.Load()
+	}
+}
+
+// Put adds an element, spinning until there is room. It yields the
+// processor between attempts but never sleeps, so it should only be used
+// when consumers are sure to make room soon.
+func (c *ItemMPMCCapsule) Put(val Item) {
+	for !c.TryPut(val) {
+		runtime.Gosched()
+	}
+}
+
+// Get removes and returns the next element, spinning until there is one,
+// like Put.
+func (c *ItemMPMCCapsule) Get() Item {
+	for {
+		if r, ok := c.TryGet(); ok {
+			return r
+		}
+		runtime.Gosched()
+	}
+}
+
+// Len returns the number of elements. With concurrent Puts and Gets, the
+// result is only a snapshot.
+func (c *ItemMPMCCapsule) Len() int {
+	head := c.head.Load()
+	n := int64(c.tail.Load() - head)
+	if n < 0 {
+		return 0
+	}
+	return min(int(n), c.Cap())
+}
+
+// Cap returns the capacity of the capsule.
+func (c *ItemMPMCCapsule) Cap() int {
+	return len(c.slots)
+}
+
+// ItemSPSCCapsule is a wait-free capsule of fixed capacity for exactly one
+// producer goroutine and one consumer goroutine. Only the producer writes
+// tail, and only the consumer writes head; each side caches the other
+// side's index and only reloads it when the cached value suggests that the
+// ring is full or empty. The indexes live on separate cache lines so that
+// the two sides do not slow each other down.
+type ItemSPSCCapsule struct {
+	s          []Item
+	mask       uint64
+	_          [64]byte
+	head       atomic.Uint64 // next position to Get from
+	cachedTail uint64        // the consumer's copy of tail
+	_          [64]byte
+	tail       atomic.Uint64 // next position to Put to
+	cachedHead uint64        // the producer's copy of head
+	_          [64]byte
+}
+
+// NewItemSPSCCapsule creates a capsule that holds at least n elements; the
+// capacity is rounded up to a power of two. It panics if n < 1.
+func NewItemSPSCCapsule(n int) *ItemSPSCCapsule {
+	if n < 1 {
+		panic("capsule: capacity of SPSC capsule must be positive")
+	}
+	size := 1
+	for size < n {
+		size *= 2
+	}
+	return &ItemSPSCCapsule{s: make([]Item, size), mask: uint64(size - 1)}
+}
+
+// TryPut adds an element if there is room, and reports whether it did so.
+// It never blocks. Only the producer may call it.
+func (c *ItemSPSCCapsule) TryPut(val Item) bool {
+	return c.PutN([]Item{val}) == 1
+}
+
+// PutN adds as many elements of vals as there is room for, and returns
+// their number. It never blocks. Only the producer may call it.
+func (c *ItemSPSCCapsule) PutN(vals []Item) int {
+	tail := c.tail.Load()
+	free := uint64(len(c.s)) - (tail - c.cachedHead)
+	if free < uint64(len(vals)) {
+		c.cachedHead = c.head.Load()
+		free = uint64(len(c.s)) - (tail - c.cachedHead)
+	}
+	n := min(uint64(len(vals)), free)
+	if n == 0 {
+		return 0
+	}
+	i := tail & c.mask
+	k := copy(c.s[i:], vals[:n])
+	copy(c.s, vals[k:n])
+	c.tail.Store(tail + n)
+	return int(n)
+}
+
+// TryGet removes and returns the next element if there is one. It never
+// blocks. Only the consumer may call it.
+func (c *ItemSPSCCapsule) TryGet() (Item, bool) {
+	var r [1]Item
+	n := c.GetN(r[:])
+	return r[0], n == 1
+}
+
+// GetN removes up to len(dst) elements, stores them in dst, and returns
+// their number. It never blocks. Only the consumer may call it.
+func (c *ItemSPSCCapsule) GetN(dst []Item) int {
+	head := c.head.Load()
+	avail := c.cachedTail - head
+	if avail < uint64(len(dst)) {
+		c.cachedTail = c.tail.Load()
+		avail = c.cachedTail - head
+	}
+	n := min(uint64(len(dst)), avail)
+	if n == 0 {
+		return 0
+	}
+	i := head & c.mask
+	k := copy(dst[:n], c.s[i:])
+	copy(dst[k:n], c.s)
+	// Do not keep the elements alive.
+	clear(c.s[i:min(i+n, uint64(len(c.s)))])
+	clear(c.s[:n-uint64(k)])
+	c.head.Store(head + n)
+	return int(n)
+}
+
+// Put adds an element, spinning until there is room. It yields the
+// processor between attempts but never sleeps. Only the producer may call
+// it.
+func (c *ItemSPSCCapsule) Put(val Item) {
+	for !c.TryPut(val) {
+		runtime.Gosched()
+	}
+}
+
+// Get removes and returns the next element, spinning until there is one,
+// like Put. Only the consumer may call it.
+func (c *ItemSPSCCapsule) Get() Item {
+	for {
+		if r, ok := c.TryGet(); ok {
+			return r
+		}
+		runtime.Gosched()
+	}
+}
+
+// Len returns the number of elements. With a concurrent Put or Get, the
+// result is only a snapshot.
+func (c *ItemSPSCCapsule) Len() int {
+	head := c.head.Load()
+	return int(c.tail.Load() - head)
+}
+
+// Cap returns the capacity of the capsule.
+func (c *ItemSPSCCapsule) Cap() int {
+	return len(c.s)
+}
+
+// ItemCodec encodes and decodes the elements of a PersistentItemCapsule.
+type ItemCodec interface {
+	// Append appends the encoding of val to b and returns the extended
+	// slice.
+	Append(b []byte, val Item) []byte
+	// Decode decodes an element from b, which holds exactly the bytes that
+	// Append has appended.
+	Decode(b []byte) (Item, error)
+}
+
+// PersistentItemCapsule is an ItemCapsule whose elements survive restarts.
+// It keeps the elements in memory and appends every Put and TryGet to a
+// write-ahead log in its directory before it applies them. Once the log has
+// grown long enough, Put and TryGet compact it into a snapshot of the elements
+// and start a new log (see PersistOptions). Open rebuilds the elements from
+// the snapshot and the log. A persistent capsule is safe for concurrent
+// use, but only one capsule at a time may use a directory.
+type PersistentItemCapsule struct {
+	mu    sync.Mutex
+	c     ItemCapsule
+	codec ItemCodec
+	opts  PersistOptions
+	dir   string
+	log   *os.File // nil after Close
+	size  int64    // size of the log
+	gen   uint64   // generation of the snapshot and the log
+	recs  int      // number of Put and Get records in the log
+	buf   []byte
+	err   error // set if the files are in a state that forbids further writes
+}
+
+// OpenPersistentItemCapsule opens the persistent capsule in dir, which gets
+// created if it does not exist. It replays the log and cuts off a record
+// at its end that a crash has left incomplete. Errors about damaged files
+// and records that make no sense wrap ErrCorrupt.
+func OpenPersistentItemCapsule(dir string, codec ItemCodec, opts PersistOptions) (*PersistentItemCapsule, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("capsule: %w", err)
+	}
+	c := &PersistentItemCapsule{codec: codec, opts: opts, dir: dir}
+	name := filepath.Join(dir, snapshotFile)
+	snap, err := os.ReadFile(name)
+	switch {
+	case err == nil:
+		gen, n := readHeader(snap)
+		if n == 0 {
+			return nil, fmt.Errorf("capsule: %s: no header: %w", name, ErrCorrupt)
+		}
+		c.gen = gen
+		end, err := readRecords(snap, n, c.replay)
+		if err != nil {
+			return nil, fmt.Errorf("capsule: %s: %w", name, err)
+		}
+		if end != len(snap) {
+			return nil, fmt.Errorf("capsule: %s: incomplete record at offset %d: %w", name, end, ErrCorrupt)
+		}
+		c.recs = 0 // the snapshot's records do not count
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("capsule: %w", err)
+	}
+
+	name = filepath.Join(dir, logFile)
+	data, err := os.ReadFile(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("capsule: %w", err)
+	}
+	gen, n := readHeader(data)
+	switch {
+	case n == 0 && len(data) > 0:
+		// createLog renames the log into place, so a crash cannot have
+		// damaged the header.
+		return nil, fmt.Errorf("capsule: %s: no header: %w", name, ErrCorrupt)
+	case n == 0 || gen < c.gen:
+		// There is no log yet, or it is stale because the snapshot already
+		// contains its changes.
+		c.log, c.size, err = createLog(dir, c.gen)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	case gen > c.gen:
+		return nil, fmt.Errorf("capsule: %s: log of generation %d needs the missing snapshot of that generation: %w", name, gen, ErrCorrupt)
+	}
+	end, err := readRecords(data, n, c.replay)
+	if err != nil {
+		return nil, fmt.Errorf("capsule: %s: %w", name, err)
+	}
+	c.size = int64(end)
+	c.log, err = os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err == nil && c.size < int64(len(data)) {
+		err = c.log.Truncate(c.size)
+		if err == nil {
+			err = c.log.Sync()
+		}
+	}
+	if err != nil {
+		if c.log != nil {
+			c.log.Close()
+		}
+		return nil, fmt.Errorf("capsule: %w", err)
+	}
+	return c, nil
+}
+
+// replay applies a record of the snapshot or the log to the elements.
+func (c *PersistentItemCapsule) replay(kind byte, payload []byte) error {
+	switch kind {
+	case recPut:
+		val, err := c.codec.Decode(payload)
+		if err != nil {
+			return fmt.Errorf("cannot decode element: %v: %w", err, ErrCorrupt)
+		}
+		c.c.Put(val)
+	case recGet:
+		if c.c.Len() == 0 {
+			return fmt.Errorf("Get from empty capsule: %w", ErrCorrupt)
+		}
+		c.c.Get()
+	default:
+		return fmt.Errorf("unknown record kind %d: %w", kind, ErrCorrupt)
+	}
+	c.recs++
+	return nil
+}
+
+// Put adds an element. It returns once the Put is in the log.
+func (c *PersistentItemCapsule) Put(val Item) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.prepare(); err != nil {
+		return err
+	}
+	b := beginRecord(c.buf[:0])
+	b = c.codec.Append(b, val)
+	sealRecord(b, recPut)
+	c.buf = b
+	if err := c.write(b); err != nil {
+		return err
+	}
+	c.c.Put(val)
+	return nil
+}
+
+// TryGet removes and returns the next element if there is one. It returns
+// once the Get is in the log.
+func (c *PersistentItemCapsule) TryGet() (Item, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var zero Item
+	if err := c.prepare(); err != nil {
+		return zero, false, err
+	}
+	if c.c.Len() == 0 {
+		return zero, false, nil
+	}
+	c.buf = appendRecord(c.buf[:0], recGet, nil)
+	if err := c.write(c.buf); err != nil {
+		return zero, false, err
+	}
+	return c.c.Get(), true, nil
+}
+
+func (c *PersistentItemCapsule) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.c.Len()
+}
+
+// Compact writes a snapshot of the elements and starts a new log. Put and
+// TryGet do this on their own; see PersistOptions.
+func (c *PersistentItemCapsule) Compact() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.log == nil {
+		return ErrClosed
+	}
+	if c.err != nil {
+		return c.err
+	}
+	return c.compact()
+}
+
+// Close closes the log. The capsule cannot be used anymore, except for Len.
+// Close is idempotent.
+func (c *PersistentItemCapsule) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.log == nil {
+		return nil
+	}
+	var err error
+	if c.opts.NoSync && c.err == nil {
+		err = c.log.Sync()
+	}
+	if cerr := c.log.Close(); err == nil {
+		err = cerr
+	}
+	c.log = nil
+	if err != nil {
+		return fmt.Errorf("capsule: %w", err)
+	}
+	return nil
+}
+
+// prepare checks whether the capsule can take another record, and compacts
+// the log if it is due. c.mu must be held.
+func (c *PersistentItemCapsule) prepare() error {
+	if c.log == nil {
+		return ErrClosed
+	}
+	if c.err != nil {
+		return c.err
+	}
+	if c.recs >= c.opts.compactAfter() && c.recs >= c.c.Len() {
+		return c.compact()
+	}
+	return nil
+}
+
+// write appends the record rec to the log. If that fails, write cuts off
+// what may have made it into the log, so that the log stays valid. c.mu
+// must be held.
+func (c *PersistentItemCapsule) write(rec []byte) error {
+	_, err := c.log.Write(rec)
+	if err == nil && !c.opts.NoSync {
+		err = c.log.Sync()
+	}
+	if err != nil {
+		if terr := c.log.Truncate(c.size); terr != nil {
+			c.err = fmt.Errorf("capsule: cannot repair log after failed write: %w", terr)
+		}
+		return fmt.Errorf("capsule: %w", err)
+	}
+	c.size += int64(len(rec))
+	c.recs++
+	return nil
+}
+
+// compact writes a snapshot of the next generation and then a new log. If
+// the snapshot cannot be written, nothing changes. If the log cannot be
+// written after the snapshot, the old log is stale, and the capsule refuses
+// further writes; the elements are safe in the snapshot, though. c.mu must
+// be held.
+func (c *PersistentItemCapsule) compact() error {
+	gen := c.gen + 1
+	b := appendHeader(nil, gen)
+	for i := 0; i < c.c.n; i++ {
+		start := len(b)
+		b = beginRecord(b)
+		b = c.codec.Append(b, c.c.s[(c.c.head+i)%len(c.c.s)])
+		sealRecord(b[start:], recPut)
+	}
+	if err := writeFileAtomic(filepath.Join(c.dir, snapshotFile), b); err != nil {
+		return fmt.Errorf("capsule: %w", err)
+	}
+	log, size, err := createLog(c.dir, gen)
+	if err != nil {
+		c.err = fmt.Errorf("capsule: cannot start new log after compaction: %w", err)
+		return c.err
+	}
+	c.log.Close()
+	c.log, c.size, c.gen, c.recs = log, size, gen, 0
+	return nil
+}
@@ -0,0 +1,780 @@
+package capsule
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor waits until cond returns true, yielding to other goroutines in
+// between.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		runtime.Gosched()
+	}
+}
+
+// checkGoroutines fails the test if the number of goroutines does not
+// return to base soon.
+func checkGoroutines(t *testing.T, base int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > base {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines leaked", runtime.NumGoroutine()-base)
+		}
+		runtime.Gosched()
+	}
+}
+
+// waiting returns the number of Gets that wait for an element.
+func (c *Uint32BlockingCapsule) waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.waiters
+}
+
+// waiting returns the number of Puts and Gets that wait.
+func (c *BoundedUint32Capsule) waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.waiters
+}
+
+func TestBlockingGetWaitsForPut(t *testing.T) {
+	c := NewUint32BlockingCapsule()
+	got := make(chan uint32)
+	go func() {
+		v, err := c.Get(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		got <- v
+	}()
+	waitFor(t, func() bool { return c.waiting() == 1 })
+	if err := c.Put(42); err != nil {
+		t.Fatal(err)
+	}
+	if v := <-got; v != 42 {
+		t.Errorf("Get = %d, want 42", v)
+	}
+}
+
+func TestBlockingGetCancel(t *testing.T) {
+	c := NewUint32BlockingCapsule()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, err := c.Get(ctx)
+		errc <- err
+	}()
+	waitFor(t, func() bool { return c.waiting() == 1 })
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("Get = %v, want %v", err, context.Canceled)
+	}
+	if n := c.waiting(); n != 0 {
+		t.Errorf("%d waiters after cancel, want 0", n)
+	}
+	// A Put after the cancellation stays in the capsule.
+	c.Put(1)
+	if v, ok := c.TryGet(); !ok || v != 1 {
+		t.Errorf("TryGet = %d, %v, want 1, true", v, ok)
+	}
+}
+
+func TestBlockingGetDeadline(t *testing.T) {
+	c := NewUint32BlockingCapsule()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestBlockingCloseWakesWaiters(t *testing.T) {
+	const n = 5
+	c := NewUint32BlockingCapsule()
+	errc := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			_, err := c.Get(context.Background())
+			errc <- err
+		}()
+	}
+	waitFor(t, func() bool { return c.waiting() == n })
+	c.Close()
+	for i := 0; i < n; i++ {
+		if err := <-errc; err != ErrClosed {
+			t.Errorf("Get = %v, want %v", err, ErrClosed)
+		}
+	}
+	c.Close() // idempotent
+}
+
+func TestBlockingDrainAfterClose(t *testing.T) {
+	c := NewUint32BlockingCapsule()
+	c.Put(1)
+	c.Put(2)
+	c.Close()
+	if err := c.Put(3); err != ErrClosed {
+		t.Errorf("Put after Close = %v, want %v", err, ErrClosed)
+	}
+	for want := uint32(1); want <= 2; want++ {
+		if v, err := c.Get(context.Background()); err != nil || v != want {
+			t.Errorf("Get = %d, %v, want %d, nil", v, err, want)
+		}
+	}
+	if _, err := c.Get(context.Background()); err != ErrClosed {
+		t.Errorf("Get from drained capsule = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestBlockingConcurrent(t *testing.T) {
+	const producers, consumers, perProducer = 4, 4, 1000
+	c := NewUint32BlockingCapsule()
+	var seen [producers * perProducer]int32
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				c.Put(uint32(p*perProducer + i))
+			}
+		}(p)
+	}
+	var cwg sync.WaitGroup
+	for i := 0; i < consumers; i++ {
+		cwg.Add(1)
+		go func() {
+			defer cwg.Done()
+			for {
+				v, err := c.Get(context.Background())
+				if err == ErrClosed {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				seen[v]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	c.Close()
+	cwg.Wait()
+	for v, n := range seen {
+		if n != 1 {
+			t.Fatalf("element %d arrived %d times", v, n)
+		}
+	}
+}
+
+// getAll removes the elements of c without waiting.
+func getAll(c *BoundedUint32Capsule) []uint32 {
+	var res []uint32
+	for {
+		v, ok := c.TryGet()
+		if !ok {
+			return res
+		}
+		res = append(res, v)
+	}
+}
+
+func TestBoundedBlock(t *testing.T) {
+	c := NewBoundedUint32Capsule(2, Block)
+	c.Put(context.Background(), 1)
+	c.Put(context.Background(), 2)
+	errc := make(chan error)
+	go func() { errc <- c.Put(context.Background(), 3) }()
+	waitFor(t, func() bool { return c.waiting() == 1 })
+	if v, err := c.Get(context.Background()); err != nil || v != 1 {
+		t.Fatalf("Get = %d, %v, want 1, nil", v, err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("blocked Put = %v", err)
+	}
+	if got := getAll(c); !reflect.DeepEqual(got, []uint32{2, 3}) {
+		t.Errorf("elements = %v, want [2 3]", got)
+	}
+	if c.Dropped() != 0 {
+		t.Errorf("Dropped = %d, want 0", c.Dropped())
+	}
+}
+
+func TestBoundedDeadline(t *testing.T) {
+	c := NewBoundedUint32Capsule(1, Block)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get from empty capsule = %v, want %v", err, context.DeadlineExceeded)
+	}
+	c.Put(context.Background(), 1)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Put(ctx, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Put into full capsule = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := c.waiting(); n != 0 {
+		t.Errorf("%d waiters after the deadline, want 0", n)
+	}
+	if got := getAll(c); !reflect.DeepEqual(got, []uint32{1}) {
+		t.Errorf("elements = %v, want [1]", got)
+	}
+}
+
+func TestBoundedPolicies(t *testing.T) {
+	tests := []struct {
+		policy  OverflowPolicy
+		want    []uint32
+		dropped uint64
+		err     error
+	}{
+		{Reject, []uint32{1, 2, 3}, 0, ErrFull},
+		{DropOldest, []uint32{3, 4, 5}, 2, nil},
+		{DropNewest, []uint32{1, 2, 3}, 2, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			c := NewBoundedUint32Capsule(3, tt.policy)
+			for v := uint32(1); v <= 5; v++ {
+				err := c.Put(context.Background(), v)
+				if v <= 3 && err != nil || v > 3 && err != tt.err {
+					t.Errorf("Put(%d) = %v", v, err)
+				}
+			}
+			if c.Len() != 3 || c.Cap() != 3 {
+				t.Errorf("Len, Cap = %d, %d, want 3, 3", c.Len(), c.Cap())
+			}
+			if c.Dropped() != tt.dropped {
+				t.Errorf("Dropped = %d, want %d", c.Dropped(), tt.dropped)
+			}
+			if got := getAll(c); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("elements = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBoundedCloseWakesPut(t *testing.T) {
+	c := NewBoundedUint32Capsule(1, Block)
+	c.Put(context.Background(), 1)
+	errc := make(chan error)
+	go func() { errc <- c.Put(context.Background(), 2) }()
+	waitFor(t, func() bool { return c.waiting() == 1 })
+	c.Close()
+	if err := <-errc; err != ErrClosed {
+		t.Errorf("blocked Put = %v, want %v", err, ErrClosed)
+	}
+	if v, err := c.Get(context.Background()); err != nil || v != 1 {
+		t.Errorf("Get after Close = %d, %v, want 1, nil", v, err)
+	}
+	if _, err := c.Get(context.Background()); err != ErrClosed {
+		t.Errorf("Get from drained capsule = %v, want %v", err, ErrClosed)
+	}
+	c.Close() // idempotent
+}
+
+func TestBoundedConcurrent(t *testing.T) {
+	const producers, consumers, perProducer = 4, 4, 1000
+	const total = producers * perProducer
+	for _, policy := range []OverflowPolicy{Block, DropOldest} {
+		t.Run(policy.String(), func(t *testing.T) {
+			c := NewBoundedUint32Capsule(3, policy)
+			var seen [total]int32
+			var mu sync.Mutex
+			var wg sync.WaitGroup
+			for p := 0; p < producers; p++ {
+				wg.Add(1)
+				go func(p int) {
+					defer wg.Done()
+					for i := 0; i < perProducer; i++ {
+						if err := c.Put(context.Background(), uint32(p*perProducer+i)); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}(p)
+			}
+			var cwg sync.WaitGroup
+			for i := 0; i < consumers; i++ {
+				cwg.Add(1)
+				go func() {
+					defer cwg.Done()
+					for {
+						v, err := c.Get(context.Background())
+						if err == ErrClosed {
+							return
+						}
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						mu.Lock()
+						seen[v]++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+			c.Close()
+			cwg.Wait()
+			received := 0
+			for v, n := range seen {
+				if n > 1 {
+					t.Fatalf("element %d arrived %d times", v, n)
+				}
+				received += int(n)
+			}
+			if received+int(c.Dropped()) != total {
+				t.Errorf("%d elements received and %d dropped, want %d in total", received, c.Dropped(), total)
+			}
+			if policy == Block && received != total {
+				t.Errorf("%d elements received, want %d", received, total)
+			}
+		})
+	}
+}
+
+func TestMPMCFullAndEmpty(t *testing.T) {
+	c := NewUint32MPMCCapsule(3)
+	if c.Cap() != 4 {
+		t.Fatalf("Cap = %d, want 4", c.Cap())
+	}
+	// Several rounds, so that the positions wrap around the ring.
+	for round := uint32(0); round < 3; round++ {
+		for i := uint32(0); i < 4; i++ {
+			if !c.TryPut(round*4 + i) {
+				t.Fatalf("TryPut %d failed", i)
+			}
+		}
+		if c.TryPut(99) {
+			t.Fatal("TryPut into full capsule succeeded")
+		}
+		if c.Len() != 4 {
+			t.Errorf("Len = %d, want 4", c.Len())
+		}
+		for i := uint32(0); i < 4; i++ {
+			if v, ok := c.TryGet(); !ok || v != round*4+i {
+				t.Fatalf("TryGet = %d, %v, want %d, true", v, ok, round*4+i)
+			}
+		}
+		if _, ok := c.TryGet(); ok {
+			t.Fatal("TryGet from empty capsule succeeded")
+		}
+	}
+}
+
+func TestMPMCStress(t *testing.T) {
+	const producers, consumers, perProducer = 4, 4, 5000
+	const total = producers * perProducer
+	c := NewUint32MPMCCapsule(4) // small, so that the ring wraps often
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				c.Put(uint32(p*perProducer + i))
+			}
+		}(p)
+	}
+	got := make([][]uint32, consumers)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < total/consumers; j++ {
+				got[i] = append(got[i], c.Get())
+			}
+		}(i)
+	}
+	wg.Wait()
+	seen := make([]int, total)
+	for _, vals := range got {
+		for _, v := range vals {
+			seen[v]++
+		}
+	}
+	for v, n := range seen {
+		if n != 1 {
+			t.Fatalf("element %d arrived %d times", v, n)
+		}
+	}
+	if n := c.Len(); n != 0 {
+		t.Errorf("Len = %d after the stress test, want 0", n)
+	}
+}
+
+func TestSPSCFullAndEmpty(t *testing.T) {
+	c := NewUint32SPSCCapsule(5)
+	if c.Cap() != 8 {
+		t.Fatalf("Cap = %d, want 8", c.Cap())
+	}
+	if _, ok := c.TryGet(); ok {
+		t.Fatal("TryGet from empty capsule succeeded")
+	}
+	// Start off the beginning of the ring, so that a full ring wraps.
+	c.PutN([]uint32{90, 91, 92})
+	if n := c.GetN(make([]uint32, 3)); n != 3 {
+		t.Fatalf("GetN = %d, want 3", n)
+	}
+	for i := uint32(0); i < 8; i++ {
+		if !c.TryPut(i) {
+			t.Fatalf("TryPut %d failed", i)
+		}
+	}
+	if c.TryPut(99) {
+		t.Fatal("TryPut into full capsule succeeded")
+	}
+	if n := c.PutN([]uint32{99, 99}); n != 0 {
+		t.Fatalf("PutN into full capsule = %d, want 0", n)
+	}
+	if c.Len() != 8 {
+		t.Errorf("Len = %d, want 8", c.Len())
+	}
+	if v, ok := c.TryGet(); !ok || v != 0 {
+		t.Fatalf("TryGet = %d, %v, want 0, true", v, ok)
+	}
+	// One slot is free, so PutN takes only the first value.
+	if n := c.PutN([]uint32{8, 99}); n != 1 {
+		t.Fatalf("PutN = %d, want 1", n)
+	}
+	got := make([]uint32, 10)
+	n := c.GetN(got)
+	if want := []uint32{1, 2, 3, 4, 5, 6, 7, 8}; !reflect.DeepEqual(got[:n], want) {
+		t.Errorf("GetN = %v, want %v", got[:n], want)
+	}
+	if c.Len() != 0 {
+		t.Errorf("Len = %d, want 0", c.Len())
+	}
+}
+
+func TestSPSCStress(t *testing.T) {
+	const total = 20000
+	c := NewUint32SPSCCapsule(8)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Batches of 3 and 5 do not divide the capacity of 8, so they
+		// wrap around the end of the ring at ever different positions.
+		batch := make([]uint32, 3)
+		for next := uint32(0); next < total; {
+			for i := range batch {
+				batch[i] = next + uint32(i)
+			}
+			rest := batch[:min(len(batch), total-int(next))]
+			for len(rest) > 0 {
+				n := c.PutN(rest)
+				rest = rest[n:]
+				next += uint32(n)
+				if n == 0 {
+					runtime.Gosched()
+				}
+			}
+		}
+	}()
+	buf := make([]uint32, 5)
+	for want := uint32(0); want < total; {
+		n := c.GetN(buf)
+		if n == 0 {
+			runtime.Gosched()
+			continue
+		}
+		for _, v := range buf[:n] {
+			if v != want {
+				t.Fatalf("got %d, want %d", v, want)
+			}
+			want++
+		}
+	}
+	<-done
+	if c.Len() != 0 {
+		t.Errorf("Len = %d after the stress test, want 0", c.Len())
+	}
+}
+
+func TestToChanDrains(t *testing.T) {
+	base := runtime.NumGoroutine()
+	c := NewUint32Capsule()
+	for i := uint32(0); i < 10; i++ {
+		c.Put(i)
+	}
+	want := uint32(0)
+	for v := range c.ToChan(context.Background()) {
+		if v != want {
+			t.Fatalf("received %d, want %d", v, want)
+		}
+		want++
+	}
+	if want != 10 || c.Len() != 0 {
+		t.Errorf("received %d elements, %d left, want 10 and 0", want, c.Len())
+	}
+	checkGoroutines(t, base)
+}
+
+func TestToChanCancel(t *testing.T) {
+	base := runtime.NumGoroutine()
+	c := NewUint32Capsule()
+	for i := uint32(0); i < 10; i++ {
+		c.Put(i)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := c.ToChan(ctx)
+	<-ch
+	<-ch
+	cancel()
+	checkGoroutines(t, base)
+	// The goroutine has closed the channel and kept what nobody received.
+	if _, ok := <-ch; ok {
+		t.Error("channel still open after cancel")
+	}
+	if c.Len() != 8 {
+		t.Errorf("Len = %d after cancel, want 8", c.Len())
+	}
+	if v := c.Get(); v != 2 {
+		t.Errorf("Get = %d after cancel, want 2", v)
+	}
+}
+
+func TestFromChan(t *testing.T) {
+	base := runtime.NumGoroutine()
+	c := NewUint32Capsule()
+	ch := make(chan uint32)
+	go func() {
+		for i := uint32(0); i < 10; i++ {
+			ch <- i
+		}
+		close(ch)
+	}()
+	if err := c.FromChan(context.Background(), ch); err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 10 {
+		t.Errorf("Len = %d, want 10", c.Len())
+	}
+	checkGoroutines(t, base)
+}
+
+func TestFromChanCancel(t *testing.T) {
+	base := runtime.NumGoroutine()
+	c := NewUint32Capsule()
+	ch := make(chan uint32)
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() { errc <- c.FromChan(ctx, ch) }()
+	ch <- 1
+	ch <- 2
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("FromChan = %v, want %v", err, context.Canceled)
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len = %d, want 2", c.Len())
+	}
+	checkGoroutines(t, base)
+}
+
+// openTest opens the persistent capsule in dir.
+func openTest(t *testing.T, dir string) *PersistentUint32Capsule {
+	t.Helper()
+	c, err := OpenPersistentUint32Capsule(dir, BinaryUint32Codec{}, PersistOptions{NoSync: true, CompactAfter: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// take removes len(want) elements from c and checks that they are want.
+func take(t *testing.T, c *PersistentUint32Capsule, want ...uint32) {
+	t.Helper()
+	for _, w := range want {
+		v, ok, err := c.TryGet()
+		if err != nil || !ok || v != w {
+			t.Fatalf("TryGet = %d, %v, %v, want %d, true, nil", v, ok, err, w)
+		}
+	}
+}
+
+// fill adds the elements from to to to c.
+func fill(t *testing.T, c *PersistentUint32Capsule, from, to uint32) {
+	t.Helper()
+	for v := from; v <= to; v++ {
+		if err := c.Put(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func closeTest(t *testing.T, c *PersistentUint32Capsule) {
+	t.Helper()
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestPersistentBadRecords(t *testing.T) {
+	const hdr, rec = recHeaderLen + 8, recHeaderLen + 4
+	tests := []struct {
+		name   string
+		damage func([]byte) []byte
+		left   int // elements after Open, or -1 for ErrCorrupt
+		size   int // size of the log after Open
+	}{
+		{"torn header", func(b []byte) []byte { return b[:len(b)-rec+3] }, 2, hdr + 2*rec},
+		{"torn payload", func(b []byte) []byte { return b[:len(b)-2] }, 2, hdr + 2*rec},
+		{"empty log", func(b []byte) []byte { return nil }, 0, hdr},
+		{"bad payload at end", func(b []byte) []byte { b[len(b)-1] ^= 1; return b }, -1, hdr + 3*rec},
+		{"bad payload in the middle", func(b []byte) []byte { b[hdr+rec-1] ^= 1; return b }, -1, hdr + 3*rec},
+		{"bad length in the middle", func(b []byte) []byte { b[hdr+8]++; return b }, -1, hdr + 3*rec},
+		{"huge length in the middle", func(b []byte) []byte { b[hdr+11] = 0x7f; return b }, -1, hdr + 3*rec},
+		{"bad log header", func(b []byte) []byte { b[0] ^= 1; return b }, -1, hdr + 3*rec},
+		{"torn log header", func(b []byte) []byte { return b[:hdr-1] }, -1, hdr - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c := openTest(t, dir)
+			fill(t, c, 1, 3)
+			closeTest(t, c)
+			name := filepath.Join(dir, logFile)
+			if err := os.WriteFile(name, tt.damage(readFile(t, name)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			c, err := OpenPersistentUint32Capsule(dir, BinaryUint32Codec{}, PersistOptions{NoSync: true})
+			switch {
+			case tt.left < 0:
+				if !errors.Is(err, ErrCorrupt) {
+					t.Errorf("Open = %v, want ErrCorrupt", err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			default:
+				if c.Len() != tt.left {
+					t.Errorf("Len = %d, want %d", c.Len(), tt.left)
+				}
+				closeTest(t, c)
+			}
+			if n := len(readFile(t, name)); n != tt.size {
+				t.Errorf("log is %d bytes, want %d", n, tt.size)
+			}
+		})
+	}
+}
+
+func TestPersistentReopen(t *testing.T) {
+	dir := t.TempDir()
+	c := openTest(t, dir)
+	fill(t, c, 1, 5)
+	take(t, c, 1, 2)
+	closeTest(t, c)
+
+	// The log has the Gets as well as the Puts.
+	c = openTest(t, dir)
+	if c.Len() != 3 {
+		t.Fatalf("Len = %d after reopen, want 3", c.Len())
+	}
+	take(t, c, 3)
+	fill(t, c, 6, 7)
+	closeTest(t, c)
+
+	c = openTest(t, dir)
+	take(t, c, 4, 5, 6, 7)
+	if _, ok, _ := c.TryGet(); ok {
+		t.Error("TryGet from empty capsule succeeded")
+	}
+	closeTest(t, c)
+}
+
+func TestPersistentCompaction(t *testing.T) {
+	dir := t.TempDir()
+	c := openTest(t, dir)
+	// CompactAfter is 8, so this compacts a few times, and the last log
+	// has records after the last snapshot.
+	fill(t, c, 1, 20)
+	take(t, c, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	fill(t, c, 21, 23)
+	if c.gen == 0 {
+		t.Fatal("no compaction")
+	}
+	gen := c.gen
+	closeTest(t, c)
+
+	c = openTest(t, dir)
+	if c.gen != gen {
+		t.Errorf("generation %d after reopen, want %d", c.gen, gen)
+	}
+	take(t, c, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23)
+	if c.Len() != 0 {
+		t.Errorf("%d elements left, want 0", c.Len())
+	}
+
+	// An explicit compaction leaves an empty log.
+	fill(t, c, 24, 25)
+	if err := c.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	closeTest(t, c)
+	if n := len(readFile(t, filepath.Join(dir, logFile))); n != recHeaderLen+8 {
+		t.Errorf("log is %d bytes after Compact, want %d", n, recHeaderLen+8)
+	}
+	c = openTest(t, dir)
+	take(t, c, 24, 25)
+	closeTest(t, c)
+}
+
+func TestPersistentCrashDuringCompaction(t *testing.T) {
+	dir := t.TempDir()
+	c := openTest(t, dir)
+	fill(t, c, 1, 3)
+	closeTest(t, c)
+	name := filepath.Join(dir, logFile)
+	old := readFile(t, name)
+
+	c = openTest(t, dir)
+	if err := c.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	closeTest(t, c)
+	// A crash between the rename of the snapshot and the rename of the
+	// new log leaves the old log, whose changes are in the snapshot.
+	if err := os.WriteFile(name, old, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c = openTest(t, dir)
+	take(t, c, 1, 2, 3)
+	if c.Len() != 0 {
+		t.Errorf("%d elements left, want 0", c.Len())
+	}
+	closeTest(t, c)
+	if n := len(readFile(t, name)); n == len(old) {
+		t.Error("stale log was not replaced")
+	}
+}
@@ -0,0 +1,14 @@
+package capsule
+
+import "errors"
+
+var (
+	// ErrClosed is returned by the blocking capsules after Close.
+	ErrClosed = errors.New("capsule: closed")
+	// ErrFull is returned by Put of a full bounded capsule with the Reject
+	// policy.
+	ErrFull = errors.New("capsule: full")
+	// ErrCorrupt is wrapped by the errors about files of a persistent
+	// capsule that cannot be read back.
+	ErrCorrupt = errors.New("capsule: corrupt file")
+)
@@ -0,0 +1,33 @@
+package capsule
+
+import "fmt"
+
+// An OverflowPolicy decides what Put does when it finds a bounded capsule
+// full.
+type OverflowPolicy int
+
+const (
+	// Block makes Put wait until a Get makes room.
+	Block OverflowPolicy = iota
+	// Reject makes Put return ErrFull.
+	Reject
+	// DropOldest makes Put discard the oldest element to make room for the
+	// new one.
+	DropOldest
+	// DropNewest makes Put discard the new element.
+	DropNewest
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case Block:
+		return "Block"
+	case Reject:
+		return "Reject"
+	case DropOldest:
+		return "DropOldest"
+	case DropNewest:
+		return "DropNewest"
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
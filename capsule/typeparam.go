@@ -0,0 +1,73 @@
+package capsule
+
+// Capsule is the type parameter counterpart of the ItemCapsule template.
+// It needs no code generation step; Capsule[uint32] behaves like Uint32Capsule.
+type Capsule[T any] struct {
+	s    []T
+	head int // index of the next element to Get
+	n    int // number of elements
+}
+
+func New[T any]() *Capsule[T] {
+	return &Capsule[T]{}
+}
+
+func (c *Capsule[T]) Put(val T) {
+	if c.n == len(c.s) {
+		c.resize(2 * c.n)
+	}
+	c.s[(c.head+c.n)%len(c.s)] = val
+	c.n++
+}
+
+func (c *Capsule[T]) Get() T {
+	r, ok := c.TryGet()
+	if !ok {
+		panic("capsule: Get from empty capsule")
+	}
+	return r
+}
+
+func (c *Capsule[T]) Len() int {
+	return c.n
+}
+
+// TryGet is like Get but returns false instead of panicking if c is empty.
+func (c *Capsule[T]) TryGet() (T, bool) {
+	var zero T
+	if c.n == 0 {
+		return zero, false
+	}
+	r := c.s[c.head]
+	c.s[c.head] = zero // do not keep the element alive
+	c.head = (c.head + 1) % len(c.s)
+	c.n--
+	if c.n <= len(c.s)/4 {
+		c.resize(len(c.s) / 2)
+	}
+	return r, true
+}
+
+// Peek returns the next element without removing it.
+func (c *Capsule[T]) Peek() (T, bool) {
+	if c.n == 0 {
+		var zero T
+		return zero, false
+	}
+	return c.s[c.head], true
+}
+
+// resize works like ItemCapsule.resize.
+func (c *Capsule[T]) resize(size int) {
+	if size < 8 {
+		size = 8
+	}
+	if size == len(c.s) {
+		return
+	}
+	s := make([]T, size)
+	k := copy(s, c.s[c.head:min(c.head+c.n, len(c.s))])
+	copy(s[k:], c.s[:c.n-k])
+	c.s = s
+	c.head = 0
+}
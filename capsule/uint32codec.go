@@ -0,0 +1,21 @@
+package capsule
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// BinaryUint32Codec is a Uint32Codec that encodes each element in four
+// bytes, in little-endian byte order.
+type BinaryUint32Codec struct{}
+
+func (BinaryUint32Codec) Append(b []byte, val uint32) []byte {
+	return binary.LittleEndian.AppendUint32(b, val)
+}
+
+func (BinaryUint32Codec) Decode(b []byte) (uint32, error) {
+	if len(b) != 4 {
+		return 0, fmt.Errorf("capsule: uint32 needs 4 bytes, got %d", len(b))
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
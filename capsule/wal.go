@@ -0,0 +1,205 @@
+package capsule
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+// The persistent capsules keep two files in their directory: a snapshot of
+// the elements as of the last compaction, and a write-ahead log of the Puts
+// and Gets since then. Both are sequences of records of the form
+//
+//	hdrsum    uint32  CRC-32C of sum, length, and kind
+//	sum       uint32  CRC-32C of the payload
+//	length    uint32  length of the payload
+//	kind      byte
+//	payload   [length]byte
+//
+// with all integers in little-endian byte order. Each file starts with a
+// header record that holds the generation of the snapshot, which counts the
+// compactions. A log belongs to the snapshot of the same generation.
+//
+// A crash while appending to the log can leave an incomplete record at its
+// end. The separate checksum of the record header tells such a record,
+// which Open cuts off, from a damaged one, which it reports: the record is
+// incomplete only if the log ends within its header, or if the header is
+// valid and the log ends within its payload.
+//
+// Compaction writes the new snapshot and then a new, empty log. Both go
+// through a temporary file that is renamed into place, so that a crash
+// leaves either the old or the new file. A crash between the two renames
+// leaves a log of the previous generation, which is stale and gets dropped
+// on open, as the snapshot already contains its changes.
+
+const (
+	snapshotFile = "snapshot"
+	logFile      = "wal"
+)
+
+// Kinds of records
+const (
+	recHeader byte = iota + 1 // payload: generation as uint64
+	recPut                    // payload: the element, encoded by the codec
+	recGet                    // no payload
+)
+
+const recHeaderLen = 13 // checksums, length, and kind
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// PersistOptions configures a persistent capsule. The zero value is a safe
+// default.
+type PersistOptions struct {
+	// NoSync skips the fsync after each record. Records then survive a crash
+	// of the process but not necessarily a crash of the operating system.
+	NoSync bool
+	// CompactAfter is the number of log records after which Put and TryGet
+	// compact the log into a new snapshot, provided that the log has at
+	// least as many records as the capsule has elements. Zero means 1024.
+	CompactAfter int
+}
+
+func (o PersistOptions) compactAfter() int {
+	if o.CompactAfter <= 0 {
+		return 1024
+	}
+	return o.CompactAfter
+}
+
+// beginRecord appends the space for a record header to b. The caller
+// appends the payload and then calls sealRecord.
+func beginRecord(b []byte) []byte {
+	return append(b, make([]byte, recHeaderLen)...)
+}
+
+// sealRecord fills in the header of rec, which consists of the space from
+// beginRecord and the payload.
+func sealRecord(rec []byte, kind byte) {
+	binary.LittleEndian.PutUint32(rec[8:], uint32(len(rec)-recHeaderLen))
+	rec[12] = kind
+	binary.LittleEndian.PutUint32(rec[4:], crc32.Checksum(rec[recHeaderLen:], crcTable))
+	binary.LittleEndian.PutUint32(rec, crc32.Checksum(rec[4:recHeaderLen], crcTable))
+}
+
+// appendRecord appends a complete record to b.
+func appendRecord(b []byte, kind byte, payload []byte) []byte {
+	start := len(b)
+	b = append(beginRecord(b), payload...)
+	sealRecord(b[start:], kind)
+	return b
+}
+
+// appendHeader appends a header record for generation gen to b.
+func appendHeader(b []byte, gen uint64) []byte {
+	return appendRecord(b, recHeader, binary.LittleEndian.AppendUint64(nil, gen))
+}
+
+// nextRecord parses the record at the start of b. It returns the length of
+// the record, or 0 if there is no valid record. In that case, torn reports
+// whether the record is incomplete rather than damaged.
+func nextRecord(b []byte) (kind byte, payload []byte, n int, torn bool) {
+	if len(b) < recHeaderLen {
+		return 0, nil, 0, true
+	}
+	if binary.LittleEndian.Uint32(b) != crc32.Checksum(b[4:recHeaderLen], crcTable) {
+		return 0, nil, 0, false
+	}
+	size := binary.LittleEndian.Uint32(b[8:])
+	if uint64(size) > uint64(len(b)-recHeaderLen) {
+		return 0, nil, 0, true
+	}
+	n = recHeaderLen + int(size)
+	if binary.LittleEndian.Uint32(b[4:]) != crc32.Checksum(b[recHeaderLen:n], crcTable) {
+		return 0, nil, 0, false
+	}
+	return b[12], b[recHeaderLen:n], n, false
+}
+
+// readHeader parses the header record at the start of data. It returns the
+// generation and the length of the header, or 0 if there is no valid
+// header.
+func readHeader(data []byte) (gen uint64, n int) {
+	kind, payload, n, _ := nextRecord(data)
+	if n == 0 || kind != recHeader || len(payload) != 8 {
+		return 0, 0
+	}
+	return binary.LittleEndian.Uint64(payload), n
+}
+
+// readRecords calls apply for each record in data from offset off on, up to
+// the end of data or an incomplete record. It returns the offset at which
+// it has stopped. Damaged records and errors from apply stop it with an
+// error.
+func readRecords(data []byte, off int, apply func(kind byte, payload []byte) error) (int, error) {
+	for off < len(data) {
+		kind, payload, n, torn := nextRecord(data[off:])
+		if torn {
+			break
+		}
+		if n == 0 {
+			return off, fmt.Errorf("bad record at offset %d: %w", off, ErrCorrupt)
+		}
+		if err := apply(kind, payload); err != nil {
+			return off, err
+		}
+		off += n
+	}
+	return off, nil
+}
+
+// writeFileAtomic replaces the file name with data. A crash leaves either
+// the old or the new file.
+func writeFileAtomic(name string, data []byte) error {
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(name))
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// createLog creates an empty log of generation gen in dir, replacing any
+// existing log, and opens it for appending. It returns the file and its
+// size.
+func createLog(dir string, gen uint64) (*os.File, int64, error) {
+	name := filepath.Join(dir, logFile)
+	hdr := appendHeader(nil, gen)
+	if err := writeFileAtomic(name, hdr); err != nil {
+		return nil, 0, fmt.Errorf("capsule: %w", err)
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("capsule: %w", err)
+	}
+	return f, int64(len(hdr)), nil
+}
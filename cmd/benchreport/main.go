@@ -0,0 +1,148 @@
+// Command benchreport turns the output of `go test -bench` into comparison
+// tables.
+//
+// The benchmarks in benchmark_test.go each run one table, with a
+// sub-benchmark named row/column per cell, as in
+// BenchmarkTechniques/burst/uint32/10/Container. benchreport prints one
+// table per Benchmark function, with the rows on the left and the columns
+// on top. Each cell shows ns/op and, in parentheses, allocs/op, followed by
+// any additional metrics that the benchmark reports. A "-" marks a
+// technique that does not apply to a row. Lines that are not benchmark
+// results are ignored.
+//
+// Usage:
+//
+//	go test -run '^$' -bench . | go run ./cmd/benchreport
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// A table collects the results of one Benchmark function.
+type table struct {
+	name    string
+	columns []string
+	rows    []string
+	cells   map[[2]string]string // row, column -> formatted result
+	units   []string             // units of the additional metrics
+}
+
+// procs matches the GOMAXPROCS suffix of benchmark names.
+var procs = regexp.MustCompile(`-\d+$`)
+
+func main() {
+	if len(os.Args) > 1 {
+		fmt.Fprintf(os.Stderr, "usage: go test -run '^$' -bench . | benchreport\n")
+		os.Exit(2)
+	}
+	tables, err := parse(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "benchreport:", err)
+		os.Exit(1)
+	}
+	for _, t := range tables {
+		t.print(os.Stdout)
+	}
+}
+
+// parse reads benchmark results from r and sorts them into tables, in the
+// order in which they occur.
+func parse(r io.Reader) ([]*table, error) {
+	var tables []*table
+	byName := map[string]*table{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") || fields[3] != "ns/op" {
+			continue
+		}
+		name := procs.ReplaceAllString(strings.TrimPrefix(fields[0], "Benchmark"), "")
+		parts := strings.Split(name, "/")
+		if len(parts) < 3 {
+			continue // not a cell of a table
+		}
+		t := byName[parts[0]]
+		if t == nil {
+			t = &table{name: parts[0], cells: map[[2]string]string{}}
+			byName[t.name] = t
+			tables = append(tables, t)
+		}
+		row := strings.Join(parts[1:len(parts)-1], "/")
+		col := parts[len(parts)-1]
+		if !contains(t.rows, row) {
+			t.rows = append(t.rows, row)
+		}
+		if !contains(t.columns, col) {
+			t.columns = append(t.columns, col)
+		}
+		t.cells[[2]string{row, col}] = t.cell(fields[2:])
+	}
+	return tables, sc.Err()
+}
+
+// cell formats the metrics of a result, given as value-unit pairs.
+func (t *table) cell(metrics []string) string {
+	var ns, allocs string
+	var extra []string
+	for i := 0; i+1 < len(metrics); i += 2 {
+		v, unit := metrics[i], metrics[i+1]
+		switch unit {
+		case "ns/op":
+			ns = v
+		case "allocs/op":
+			allocs = v
+		case "B/op", "MB/s":
+		default:
+			if !contains(t.units, unit) {
+				t.units = append(t.units, unit)
+			}
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				v = strconv.FormatFloat(f, 'f', 0, 64)
+			}
+			extra = append(extra, v)
+		}
+	}
+	s := ns
+	if allocs != "" {
+		s += " (" + allocs + ")"
+	}
+	for _, v := range extra {
+		s += " " + v
+	}
+	return s
+}
+
+func (t *table) print(w io.Writer) {
+	units := append([]string{"ns/op, allocs/op"}, t.units...)
+	fmt.Fprintf(w, "\n%s (%s)\n\n", t.name, strings.Join(units, ", "))
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "\t%s\t\n", strings.Join(t.columns, "\t"))
+	for _, row := range t.rows {
+		cells := make([]string, len(t.columns))
+		for i, col := range t.columns {
+			cells[i] = "-"
+			if c, ok := t.cells[[2]string{row, col}]; ok {
+				cells[i] = c
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t\n", row, strings.Join(cells, "\t"))
+	}
+	tw.Flush()
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
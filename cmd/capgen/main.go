@@ -0,0 +1,523 @@
+// Command capgen instantiates a genny-style code template.
+//
+// A template is a compilable Go file that declares one or more placeholder
+// types with `generic.Type`:
+//
+//	type Item generic.Type
+//
+// capgen replaces every placeholder with a real type. Identifiers that
+// contain the placeholder name are renamed, too, so that `ItemCapsule`
+// becomes `Uint32Capsule` for `Item=uint32`. The placeholder declarations
+// and the import of the generic package are dropped, and the result is run
+// through go/format.
+//
+// Usage:
+//
+//	capgen -in=capsule/capsule.go -out=capsule/uint32capsule.go "Item=uint32"
+//	capgen -in=capsule/capsule.go -out=capsule/all.go "Item=uint32,string,float64"
+//	capgen -in=capsule/capsule.go -out=capsule/%scapsule.go "Item=uint32,string"
+//
+// Each argument maps one placeholder to a comma-separated list of types. With
+// several arguments, capgen generates every combination. If -out contains
+// "%s", each instantiation goes into a file of its own, with "%s" replaced by
+// the lowercase instantiation name; otherwise, all instantiations go into a
+// single file. Without -out, capgen writes to stdout.
+//
+// A template can consist of several files, as in -in=capsule.go,errors.go.
+// Files without placeholders are copied as they are. Declarations that do not
+// depend on a placeholder appear only once in each output file.
+//
+// With -only, capgen generates only the listed declarations of the template,
+// named as in the template, and the methods of the listed types:
+//
+//	capgen -in=capsule/capsule.go -only=ItemCapsule,NewItemCapsule "Item=string"
+//
+// Imports that the generated declarations do not use are dropped.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// genericPath is the import path of the package that provides `generic.Type`.
+const genericPath = "github.com/cheekybits/genny/generic"
+
+// A binding maps a placeholder name to a real type.
+type binding struct {
+	placeholder string
+	typ         string
+}
+
+// An instantiation is one complete set of bindings, one per placeholder.
+type instantiation []binding
+
+// name returns the identifier-friendly name of the instantiation, as used in
+// renamed identifiers, e.g. "Uint32" or "Uint32String".
+func (in instantiation) name() string {
+	var b strings.Builder
+	for _, bd := range in {
+		b.WriteString(typeName(bd.typ))
+	}
+	return b.String()
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stderr); err != nil {
+		if err == errUsage {
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "capgen:", err)
+		os.Exit(1)
+	}
+}
+
+// errUsage reports invalid command-line arguments, after the usage message
+// has been printed.
+var errUsage = errors.New("invalid usage")
+
+// run runs capgen with the given command-line arguments and writes usage
+// messages to stderr.
+func run(args []string, stderr io.Writer) error {
+	flags := flag.NewFlagSet("capgen", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	in := flags.String("in", "", "comma-separated list of template files")
+	out := flags.String("out", "", "output file; a \"%s\" in the name creates one file per instantiation (default stdout)")
+	pkg := flags.String("pkg", "", "package name of the generated code (default: the template's package name)")
+	only := flags.String("only", "", "comma-separated list of the template's declarations to generate (default: all)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: capgen -in=template.go[,file.go...] [-out=file.go] [-pkg=name] [-only=Name,...] \"Placeholder=type1,type2\"...\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if *in == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	insts, err := parseArgs(flags.Args())
+	if err != nil {
+		return err
+	}
+	var files []source
+	var names []string
+	for _, name := range strings.Split(*in, ",") {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		files = append(files, source{name, src})
+		names = append(names, filepath.ToSlash(name))
+	}
+	var keep map[string]bool
+	if *only != "" {
+		keep = map[string]bool{}
+		for _, name := range strings.Split(*only, ",") {
+			keep[name] = true
+		}
+	}
+	header := fmt.Sprintf("// Code generated by capgen from %s. DO NOT EDIT.\n\n", strings.Join(names, ", "))
+
+	if !strings.Contains(*out, "%s") {
+		code, err := generate(files, *pkg, keep, header, insts)
+		if err != nil {
+			return err
+		}
+		return write(*out, code)
+	}
+	for _, inst := range insts {
+		code, err := generate(files, *pkg, keep, header, []instantiation{inst})
+		if err != nil {
+			return err
+		}
+		if err := write(strings.ReplaceAll(*out, "%s", strings.ToLower(inst.name())), code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func write(path string, code []byte) error {
+	if path == "" {
+		_, err := os.Stdout.Write(code)
+		return err
+	}
+	return os.WriteFile(path, code, 0644)
+}
+
+// parseArgs turns arguments like "Item=uint32,string" into the list of all
+// instantiations.
+func parseArgs(args []string) ([]instantiation, error) {
+	insts := []instantiation{nil}
+	seen := map[string]bool{}
+	for _, arg := range args {
+		eq := strings.Index(arg, "=")
+		if eq <= 0 || eq == len(arg)-1 {
+			return nil, fmt.Errorf("invalid argument %q, want Placeholder=type[,type...]", arg)
+		}
+		placeholder := strings.TrimSpace(arg[:eq])
+		if seen[placeholder] {
+			return nil, fmt.Errorf("placeholder %s specified twice", placeholder)
+		}
+		seen[placeholder] = true
+		var next []instantiation
+		for _, typ := range strings.Split(arg[eq+1:], ",") {
+			typ = strings.TrimSpace(typ)
+			if typ == "" {
+				return nil, fmt.Errorf("empty type in %q", arg)
+			}
+			if _, err := parser.ParseExpr(typ); err != nil {
+				return nil, fmt.Errorf("invalid type %q: %v", typ, err)
+			}
+			for _, inst := range insts {
+				ext := append(instantiation{}, inst...)
+				next = append(next, append(ext, binding{placeholder, typ}))
+			}
+		}
+		insts = next
+	}
+	return insts, nil
+}
+
+// A source is a template file.
+type source struct {
+	name string
+	src  []byte
+}
+
+// generate produces one formatted Go file containing the given
+// instantiations of the template files. If keep is not nil, it names the
+// declarations to generate.
+func generate(files []source, pkg string, keep map[string]bool, header string, insts []instantiation) ([]byte, error) {
+	o := &output{pkg: pkg, keep: keep, imports: map[string]string{}, used: map[string]bool{}, emitted: map[string]bool{}}
+	for _, inst := range insts {
+		declared := map[string]bool{}
+		for _, file := range files {
+			if err := o.add(file, inst, declared); err != nil {
+				return nil, err
+			}
+		}
+		for _, bd := range inst {
+			if !declared[bd.placeholder] {
+				return nil, fmt.Errorf("template declares no placeholder %s", bd.placeholder)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	fmt.Fprintf(&buf, "package %s\n\n", o.pkg)
+	for p, name := range o.imports {
+		if name == "" {
+			name = path.Base(p)
+		}
+		if name != "_" && name != "." && !o.used[name] {
+			delete(o.imports, p)
+		}
+	}
+	if len(o.imports) > 0 {
+		paths := make([]string, 0, len(o.imports))
+		for path := range o.imports {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		buf.WriteString("import (\n")
+		for _, path := range paths {
+			fmt.Fprintf(&buf, "\t%s %q\n", o.imports[path], path)
+		}
+		buf.WriteString(")\n\n")
+	}
+	buf.Write(o.body.Bytes())
+	code, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %v", err)
+	}
+	return code, nil
+}
+
+// An output collects the declarations and imports of a generated file.
+type output struct {
+	pkg     string
+	keep    map[string]bool // declarations to generate, nil for all
+	body    bytes.Buffer
+	imports map[string]string // import path -> name
+	used    map[string]bool   // names of the imported packages that the declarations use
+	emitted map[string]bool   // declarations that do not depend on a placeholder occur only once
+}
+
+// add adds the declarations of one template file, instantiated with inst,
+// to o, and records the placeholders that the file declares.
+func (o *output) add(file source, inst instantiation, declared map[string]bool) error {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file.name, file.src, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	if o.pkg == "" {
+		o.pkg = f.Name.Name
+	}
+	genericName := placeholders(f, declared)
+	cmap := ast.NewCommentMap(fset, f, f.Comments)
+	// Select the declarations by their names in the template, before
+	// substitution renames them.
+	skip := map[ast.Decl]bool{}
+	for _, decl := range f.Decls {
+		if o.keep != nil && !o.keeps(decl) {
+			skip[decl] = true
+		}
+	}
+	substitute(f, inst)
+	for _, decl := range f.Decls {
+		if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.IMPORT {
+			for _, spec := range gd.Specs {
+				is := spec.(*ast.ImportSpec)
+				path, _ := strconv.Unquote(is.Path.Value)
+				if path == genericPath {
+					continue
+				}
+				name := ""
+				if is.Name != nil {
+					name = is.Name.Name
+				}
+				o.imports[path] = name
+			}
+			continue
+		}
+		if skip[decl] || isPlaceholderDecl(decl, genericName) {
+			continue
+		}
+		ast.Inspect(decl, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if x, ok := sel.X.(*ast.Ident); ok {
+					o.used[x.Name] = true
+				}
+			}
+			return true
+		})
+		var code bytes.Buffer
+		node := &printer.CommentedNode{Node: decl, Comments: cmap.Filter(decl).Comments()}
+		if err := format.Node(&code, fset, node); err != nil {
+			return err
+		}
+		if o.emitted[code.String()] {
+			continue
+		}
+		o.emitted[code.String()] = true
+		o.body.Write(code.Bytes())
+		o.body.WriteString("\n\n")
+	}
+	return nil
+}
+
+// keeps reports whether decl declares one of the names in o.keep, or is a
+// method of one of these types.
+func (o *output) keeps(decl ast.Decl) bool {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Recv != nil {
+			t := d.Recv.List[0].Type
+			if star, ok := t.(*ast.StarExpr); ok {
+				t = star.X
+			}
+			if id, ok := t.(*ast.Ident); ok && o.keep[id.Name] {
+				return true
+			}
+			return false
+		}
+		return o.keep[d.Name.Name]
+	case *ast.GenDecl:
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				if o.keep[s.Name.Name] {
+					return true
+				}
+			case *ast.ValueSpec:
+				for _, id := range s.Names {
+					if o.keep[id.Name] {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// placeholders records the placeholders that f declares as `generic.Type`
+// and returns the local name of the generic package, or "" if f does not
+// import it.
+func placeholders(f *ast.File, declared map[string]bool) string {
+	genericName := ""
+	for _, is := range f.Imports {
+		if path, _ := strconv.Unquote(is.Path.Value); path == genericPath {
+			genericName = "generic"
+			if is.Name != nil {
+				genericName = is.Name.Name
+			}
+		}
+	}
+	if genericName == "" {
+		return ""
+	}
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if isGenericType(ts.Type, genericName) {
+				declared[ts.Name.Name] = true
+			}
+		}
+	}
+	return genericName
+}
+
+func isGenericType(expr ast.Expr, genericName string) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	return ok && x.Name == genericName && sel.Sel.Name == "Type"
+}
+
+// isPlaceholderDecl reports whether decl only declares placeholder types.
+// After substitution, these read like `type uint32 generic.Type`.
+func isPlaceholderDecl(decl ast.Decl, genericName string) bool {
+	gd, ok := decl.(*ast.GenDecl)
+	if !ok || gd.Tok != token.TYPE {
+		return false
+	}
+	for _, spec := range gd.Specs {
+		if !isGenericType(spec.(*ast.TypeSpec).Type, genericName) {
+			return false
+		}
+	}
+	return true
+}
+
+// substitute replaces the placeholders in all identifiers and comments of f.
+func substitute(f *ast.File, inst instantiation) {
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.File:
+			// Do not touch the package name.
+			for _, decl := range n.Decls {
+				ast.Inspect(decl, func(n ast.Node) bool {
+					if id, ok := n.(*ast.Ident); ok {
+						id.Name = rename(id.Name, inst)
+					}
+					return true
+				})
+			}
+			return false
+		}
+		return true
+	})
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			c.Text = renameWords(c.Text, inst)
+		}
+	}
+}
+
+// rename returns the name of an identifier after substitution. The
+// placeholder itself becomes the real type (the printer does not care that
+// an identifier like "[]byte" is not a valid name). Identifiers that contain
+// the placeholder get the type name spliced in, keeping the case of the
+// first letter, so `ItemCapsule` turns into `Uint32Capsule` and
+// `newItemCapsule` into `newUint32Capsule`.
+func rename(id string, inst instantiation) string {
+	for _, bd := range inst {
+		if id == bd.placeholder {
+			return bd.typ
+		}
+	}
+	for _, bd := range inst {
+		name := typeName(bd.typ)
+		lower := lowerFirst(bd.placeholder)
+		if strings.HasPrefix(id, lower) && lower != bd.placeholder {
+			id = lowerFirst(name) + id[len(lower):]
+		}
+		id = strings.ReplaceAll(id, bd.placeholder, name)
+	}
+	return id
+}
+
+// renameWords applies rename to every identifier-like word of a comment.
+func renameWords(text string, inst instantiation) string {
+	var b strings.Builder
+	word := func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) }
+	for len(text) > 0 {
+		i := strings.IndexFunc(text, word)
+		if i < 0 {
+			b.WriteString(text)
+			break
+		}
+		b.WriteString(text[:i])
+		text = text[i:]
+		j := strings.IndexFunc(text, func(r rune) bool { return !word(r) })
+		if j < 0 {
+			j = len(text)
+		}
+		b.WriteString(rename(text[:j], inst))
+		text = text[j:]
+	}
+	return b.String()
+}
+
+// typeName turns a type expression into a capitalized name that can be part
+// of an identifier: "uint32" becomes "Uint32", "[]byte" becomes "SliceByte",
+// "map[string]int" becomes "MapStringInt", and "*big.Int" becomes "PtrBigInt".
+func typeName(typ string) string {
+	var b strings.Builder
+	upper := true
+	for i, r := range typ {
+		switch {
+		case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			if upper {
+				r = unicode.ToUpper(r)
+				upper = false
+			}
+			b.WriteRune(r)
+		case r == '*':
+			b.WriteString("Ptr")
+			upper = true
+		case r == '[' && strings.HasPrefix(typ[i:], "[]"):
+			b.WriteString("Slice")
+			upper = true
+		default:
+			upper = true
+		}
+	}
+	return b.String()
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
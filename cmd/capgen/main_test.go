@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// chdirRoot makes the repository root the working directory for the rest of
+// the test, so that template names match those in the go:generate directives.
+func chdirRoot(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join("..", "..")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// directives returns the arguments of the capgen go:generate directives in
+// file.
+func directives(t *testing.T, file string) [][]string {
+	t.Helper()
+	src, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const prefix = "//go:generate go run ./cmd/capgen "
+	var dirs [][]string
+	for _, line := range strings.Split(string(src), "\n") {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		var args []string
+		for _, arg := range strings.Fields(strings.TrimPrefix(line, prefix)) {
+			if strings.HasPrefix(arg, `"`) {
+				if arg, err = strconv.Unquote(arg); err != nil {
+					t.Fatalf("%s: %v", line, err)
+				}
+			}
+			args = append(args, arg)
+		}
+		dirs = append(dirs, args)
+	}
+	return dirs
+}
+
+// TestGolden regenerates the checked-in files and compares them byte by byte.
+func TestGolden(t *testing.T) {
+	chdirRoot(t)
+	tmp := t.TempDir()
+	n := 0
+	for _, file := range []string{"generics.go", "benchmark_test.go"} {
+		for _, args := range directives(t, file) {
+			n++
+			var want string
+			for i, arg := range args {
+				if strings.HasPrefix(arg, "-out=") {
+					want = strings.TrimPrefix(arg, "-out=")
+					args[i] = "-out=" + filepath.Join(tmp, filepath.Base(want))
+				}
+			}
+			if err := run(args, io.Discard); err != nil {
+				t.Errorf("%s: %v", file, err)
+				continue
+			}
+			got := readFile(t, filepath.Join(tmp, filepath.Base(want)))
+			if !bytes.Equal(got, readFile(t, want)) {
+				t.Errorf("%s: regenerating %s gives different output", file, want)
+			}
+		}
+	}
+	if n != 2 {
+		t.Errorf("found %d capgen directives, want 2", n)
+	}
+}
+
+// TestCompile checks that instantiations with several types compile, both as
+// one file per type and as a single file.
+func TestCompile(t *testing.T) {
+	chdirRoot(t)
+	goTool := lookGo(t)
+	types := "Item=uint32,string,float64"
+
+	perType := newModule(t, "capsule/errors.go", "capsule/policy.go", "capsule/wal.go")
+	if err := run([]string{"-in=capsule/capsule.go", "-out=" + filepath.Join(perType, "%scapsule.go"), types}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"uint32capsule.go", "stringcapsule.go", "float64capsule.go"} {
+		if _, err := os.Stat(filepath.Join(perType, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	compile(t, goTool, perType)
+
+	// A multi-file template puts the non-template files into the output once.
+	single := newModule(t)
+	in := "-in=capsule/capsule.go,capsule/errors.go,capsule/policy.go,capsule/wal.go"
+	if err := run([]string{in, "-out=" + filepath.Join(single, "all.go"), types}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	compile(t, goTool, single)
+	names := decls(t, filepath.Join(single, "all.go"))
+	for _, name := range []string{"Uint32Capsule", "StringCapsule", "Float64Capsule", "ErrClosed", "sealRecord"} {
+		if names[name] != 1 {
+			t.Errorf("all.go declares %s %d times, want once", name, names[name])
+		}
+	}
+}
+
+// TestOnly checks that -only generates just the listed declarations and
+// their methods, and drops the imports that these do not need.
+func TestOnly(t *testing.T) {
+	chdirRoot(t)
+	goTool := lookGo(t)
+	dir := newModule(t)
+	out := filepath.Join(dir, "only.go")
+	if err := run([]string{"-in=capsule/capsule.go", "-only=ItemCapsule,NewItemCapsule", "-out=" + out, "-pkg=only", "Item=string,float64"}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	compile(t, goTool, dir)
+
+	var got []string
+	for name := range decls(t, out) {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	want := []string{"Float64Capsule", "NewFloat64Capsule", "NewStringCapsule", "StringCapsule"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("declarations: got %v, want %v", got, want)
+	}
+
+	f, err := parser.ParseFile(token.NewFileSet(), out, nil, parser.ImportsOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name.Name != "only" {
+		t.Errorf("package %s, want only", f.Name.Name)
+	}
+	for _, imp := range f.Imports {
+		switch path, _ := strconv.Unquote(imp.Path.Value); path {
+		case "os", "sync/atomic", "path/filepath", genericPath:
+			t.Errorf("unused import %s was kept", path)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	chdirRoot(t)
+	for _, args := range [][]string{
+		nil,
+		{"Item=uint32"},
+		{"-in=capsule/capsule.go"},
+	} {
+		if err := run(args, io.Discard); err != errUsage {
+			t.Errorf("run(%q): got %v, want errUsage", args, err)
+		}
+	}
+	for _, args := range [][]string{
+		{"-in=capsule/capsule.go", "Item"},
+		{"-in=capsule/capsule.go", "Item=uint32", "Item=string"},
+		{"-in=capsule/capsule.go", "Elem=uint32"},
+		{"-in=capsule/missing.go", "Item=uint32"},
+	} {
+		if err := run(args, io.Discard); err == nil || err == errUsage {
+			t.Errorf("run(%q): got %v, want an error", args, err)
+		}
+	}
+}
+
+func readFile(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func lookGo(t *testing.T) string {
+	t.Helper()
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	return goTool
+}
+
+// newModule creates a module without dependencies in a temporary directory
+// and copies the given files into it.
+func newModule(t *testing.T, files ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module tmp\n\ngo 1.21\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(file)), readFile(t, file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// compile type-checks and vets the module in dir.
+func compile(t *testing.T, goTool, dir string) {
+	t.Helper()
+	cmd := exec.Command(goTool, "vet", "./...")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=-mod=mod")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go vet: %v\n%s", err, out)
+	}
+}
+
+// decls counts the top-level declarations of file by name, excluding methods.
+func decls(t *testing.T, file string) map[string]int {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]int{}
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				names[d.Name.Name]++
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					names[s.Name.Name]++
+				case *ast.ValueSpec:
+					for _, id := range s.Names {
+						names[id.Name]++
+					}
+				}
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,171 @@
+// Command golit turns a literate Go source file into a Markdown article.
+//
+// A literate Go file is a valid Go file whose top-level block comments
+// contain the text of the article. golit emits
+//
+//   - the content of every block comment that starts at the beginning of a
+//     line as Markdown prose,
+//   - the code between these comments as fenced ```go blocks, including any
+//     `//` comments, which stay inline with the code,
+//   - the Hugo front matter (between two `+++` lines) at the very top of the
+//     output, wherever it appears in the prose.
+//
+// With -strip, HTML comments like the copyright note are removed from the
+// prose. The Hugo summary separator `<!--more-->` is always kept.
+//
+// Usage:
+//
+//	golit [-strip] [-o article.md] generics.go
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+func main() {
+	out := flag.String("o", "", "output file (default stdout)")
+	strip := flag.Bool("strip", false, "remove HTML comments (except <!--more-->) from the prose")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: golit [-strip] [-o file.md] file.go\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	src, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	md, err := convert(flag.Arg(0), src, *strip)
+	if err != nil {
+		fatal(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if _, err := w.Write(md); err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "golit:", err)
+	os.Exit(1)
+}
+
+// A chunk is a piece of the source file, either prose or code.
+type chunk struct {
+	prose bool
+	text  string
+}
+
+// chunks splits src into prose and code. Prose is the content of every block
+// comment that starts in the first column; everything else is code.
+func chunks(filename string, src []byte) ([]chunk, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	var cs []chunk
+	start := 0 // start of the current code chunk
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			pos := fset.Position(c.Pos())
+			if !strings.HasPrefix(c.Text, "/*") || pos.Column != 1 {
+				continue
+			}
+			cs = append(cs, chunk{false, string(src[start:pos.Offset])})
+			cs = append(cs, chunk{true, strings.TrimSuffix(strings.TrimPrefix(c.Text, "/*"), "*/")})
+			start = fset.Position(c.End()).Offset
+		}
+	}
+	cs = append(cs, chunk{false, string(src[start:])})
+	return cs, nil
+}
+
+var (
+	frontMatter  = regexp.MustCompile(`(?ms)^\+\+\+[ \t]*\n.*?^\+\+\+[ \t]*$\n?`)
+	htmlComment  = regexp.MustCompile(`(?s)<!--(.*?)-->`)
+	excessBlanks = regexp.MustCompile(`\n{3,}`)
+)
+
+// convert turns the literate Go file src into Markdown.
+func convert(filename string, src []byte, strip bool) ([]byte, error) {
+	cs, err := chunks(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	var front string
+	var body bytes.Buffer
+	for _, c := range cs {
+		text := trimBlankLines(c.text)
+		if !c.prose {
+			if text != "" {
+				fmt.Fprintf(&body, "```go\n%s\n```\n\n", text)
+			}
+			continue
+		}
+		if front == "" {
+			if m := frontMatter.FindString(text); m != "" {
+				front = m
+				text = trimBlankLines(strings.Replace(text, m, "", 1))
+			}
+		}
+		if strip {
+			text = htmlComment.ReplaceAllStringFunc(text, func(m string) string {
+				if strings.TrimSpace(htmlComment.FindStringSubmatch(m)[1]) == "more" {
+					return m
+				}
+				return ""
+			})
+			text = trimBlankLines(excessBlanks.ReplaceAllString(text, "\n\n"))
+		}
+		if text != "" {
+			body.WriteString(text + "\n\n")
+		}
+	}
+
+	var md bytes.Buffer
+	if front != "" {
+		md.WriteString(strings.TrimSuffix(front, "\n") + "\n\n")
+	}
+	md.Write(bytes.TrimRight(body.Bytes(), "\n"))
+	md.WriteString("\n")
+	return md.Bytes(), nil
+}
+
+// trimBlankLines removes leading and trailing blank lines as well as
+// trailing white space, but keeps the indentation of the first line.
+func trimBlankLines(s string) string {
+	s = strings.TrimRight(s, " \t\r\n")
+	for {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 || strings.TrimSpace(s[:i]) != "" {
+			break
+		}
+		s = s[i+1:]
+	}
+	if strings.TrimSpace(s) == "" {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,362 @@
+// Command inlinecheck detects drift between the code that a literate Go file
+// inlines or quotes and the package that the code was copied from.
+//
+// generics.go contains a copy of the generated Uint32Capsule code, and it
+// quotes the ItemCapsule template in a ```go block of a comment. The
+// originals live in capsule/uint32capsule.go and capsule/capsule.go.
+//
+// inlinecheck compares every declaration of the literate file, and of the
+// ```go blocks in its comments, that has a counterpart in one of the package
+// files. A declaration has a counterpart if the package declares something
+// of the same name, or if it is a method of, or a `New...` constructor for,
+// a type that the package declares. Comments and formatting are ignored.
+// Declarations that only exist in the package are fine; the article may
+// show a subset.
+//
+// If a copy differs from the original, inlinecheck prints a diff and exits
+// with status 1. With -w, it replaces the outdated copies with the original
+// code instead.
+//
+// Usage:
+//
+//	inlinecheck [-w] generics.go capsule/capsule.go capsule/uint32capsule.go
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A decl is a top-level declaration, or a single spec of a grouped one.
+type decl struct {
+	key  string   // e.g. "type Uint32Capsule" or "func (Uint32Capsule) Put"
+	recv string   // receiver base type of methods
+	node ast.Node // *ast.FuncDecl or ast.Spec
+	fset *token.FileSet
+	file *token.File // file that positions are reported in
+	base int         // offset of the node's source in file
+	// comments are the comments inside the node, if the file was parsed
+	// with comments.
+	comments []*ast.CommentGroup
+}
+
+// source returns the formatted source of d, with or without the comments
+// inside of it. Doc comments are never included.
+func (d decl) source(withComments bool) string {
+	var buf bytes.Buffer
+	var n interface{} = d.node
+	if spec, ok := d.node.(ast.Spec); ok {
+		tok := map[byte]token.Token{'t': token.TYPE, 'v': token.VAR, 'c': token.CONST}[d.key[0]]
+		n = &ast.GenDecl{Tok: tok, Specs: []ast.Spec{spec}}
+	}
+	if withComments {
+		n = &printer.CommentedNode{Node: n, Comments: d.comments}
+	}
+	if err := format.Node(&buf, d.fset, n); err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	return buf.String()
+}
+
+func main() {
+	write := flag.Bool("w", false, "replace outdated copies in the literate file instead of reporting them")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: inlinecheck [-w] literate.go package_file.go...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	article := flag.Arg(0)
+	src, err := os.ReadFile(article)
+	if err != nil {
+		fatal(err)
+	}
+	originals := map[string]decl{}
+	types := map[string]bool{}
+	for _, filename := range flag.Args()[1:] {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+		if err != nil {
+			fatal(err)
+		}
+		for _, d := range decls(f, fset, nil, 0) {
+			originals[d.key] = d
+			if strings.HasPrefix(d.key, "type ") {
+				types[strings.TrimPrefix(d.key, "type ")] = true
+			}
+		}
+	}
+	copies, err := articleDecls(article, src)
+	if err != nil {
+		fatal(err)
+	}
+
+	type edit struct {
+		start, end int
+		text       string
+	}
+	var edits []edit
+	edited := map[int]bool{} // a spec with several names shows up once per name
+	drift := false
+	for _, c := range copies {
+		orig, ok := originals[c.key]
+		if !ok {
+			if isRelated(c, types) {
+				fmt.Printf("%s: %s has no counterpart in the package\n", c.position(), c.key)
+				drift = true
+			}
+			continue
+		}
+		if equal(reflect.ValueOf(c.node), reflect.ValueOf(orig.node)) {
+			continue
+		}
+		if *write {
+			if edited[c.base+c.offset(c.node.Pos())] {
+				continue
+			}
+			edited[c.base+c.offset(c.node.Pos())] = true
+			edits = append(edits, edit{c.base + c.offset(c.node.Pos()), c.base + c.offset(c.node.End()), specSource(orig)})
+			continue
+		}
+		drift = true
+		fmt.Printf("%s: %s differs from %s:\n", c.position(), c.key, orig.position())
+		fmt.Print(diff(c.source(false), orig.source(false)))
+	}
+
+	if len(edits) > 0 {
+		sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+		for _, e := range edits {
+			src = append(src[:e.start:e.start], append([]byte(e.text), src[e.end:]...)...)
+		}
+		if err := os.WriteFile(article, src, 0644); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("%s: updated %d declaration(s)\n", article, len(edits))
+	}
+	if drift {
+		os.Exit(1)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "inlinecheck:", err)
+	os.Exit(1)
+}
+
+// offset returns the offset of pos relative to the source that d was parsed from.
+func (d decl) offset(pos token.Pos) int {
+	return d.fset.Position(pos).Offset
+}
+
+// position returns the position of d in the file that contains it.
+func (d decl) position() token.Position {
+	return d.file.Position(d.file.Pos(d.base + d.offset(d.node.Pos())))
+}
+
+// specSource returns the source of d without the `type`, `var`, or `const`
+// keyword for specs, so that it can replace another spec in place.
+func specSource(d decl) string {
+	s := d.source(true)
+	if _, ok := d.node.(ast.Spec); ok {
+		s = s[strings.IndexByte(s, ' ')+1:]
+	}
+	return s
+}
+
+// isRelated reports whether a declaration without counterpart belongs to a
+// type of the package, i.e. whether it should have a counterpart.
+func isRelated(d decl, types map[string]bool) bool {
+	if types[d.recv] {
+		return true
+	}
+	name := d.key[strings.LastIndexByte(d.key, ' ')+1:]
+	return strings.HasPrefix(d.key, "func ") && types[strings.TrimPrefix(name, "New")]
+}
+
+// decls returns the declarations of f. If f was parsed from code quoted in
+// another file, outer is that file and base is the offset of the code in it;
+// otherwise, outer is nil.
+func decls(f *ast.File, fset *token.FileSet, outer *token.File, base int) []decl {
+	if outer == nil {
+		outer = fset.File(f.Pos())
+	}
+	// inside returns the comments within n, without its doc comment.
+	inside := func(n ast.Node) []*ast.CommentGroup {
+		var cgs []*ast.CommentGroup
+		for _, cg := range f.Comments {
+			if cg.Pos() >= n.Pos() && cg.End() <= n.End() {
+				cgs = append(cgs, cg)
+			}
+		}
+		return cgs
+	}
+	var ds []decl
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			key, recv := "func "+d.Name.Name, ""
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				recv = recvType(d.Recv.List[0].Type)
+				key = fmt.Sprintf("func (%s) %s", recv, d.Name.Name)
+			}
+			ds = append(ds, decl{key, recv, d, fset, outer, base, inside(d)})
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					ds = append(ds, decl{"type " + spec.Name.Name, "", spec, fset, outer, base, inside(spec)})
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						ds = append(ds, decl{d.Tok.String() + " " + name.Name, "", spec, fset, outer, base, inside(spec)})
+					}
+				}
+			}
+		}
+	}
+	return ds
+}
+
+func recvType(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return recvType(t.X)
+	case *ast.IndexExpr:
+		return recvType(t.X)
+	case *ast.IndexListExpr:
+		return recvType(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+var fence = regexp.MustCompile("(?m)^```go[ \t]*\n((?s:.*?))^```")
+
+// articleDecls returns the declarations of the literate file and of the
+// complete Go files quoted in ```go blocks of its comments.
+func articleDecls(filename string, src []byte) ([]decl, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	ds := decls(f, fset, nil, 0)
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			start := fset.Position(c.Pos()).Offset
+			for _, m := range fence.FindAllStringSubmatchIndex(c.Text, -1) {
+				code := c.Text[m[2]:m[3]]
+				if !strings.HasPrefix(strings.TrimSpace(code), "package ") {
+					continue // only complete files can be compared reliably
+				}
+				qfset := token.NewFileSet()
+				qf, err := parser.ParseFile(qfset, fset.Position(c.Pos()).Filename, code, 0)
+				if err != nil {
+					return nil, fmt.Errorf("%s: quoted code: %v", fset.Position(c.Pos()), err)
+				}
+				ds = append(ds, decls(qf, qfset, fset.File(f.Pos()), start+m[2])...)
+			}
+		}
+	}
+	return ds, nil
+}
+
+var (
+	posType    = reflect.TypeOf(token.Pos(0))
+	objType    = reflect.TypeOf((*ast.Object)(nil))
+	scopeType  = reflect.TypeOf((*ast.Scope)(nil))
+	cgroupType = reflect.TypeOf((*ast.CommentGroup)(nil))
+)
+
+// equal compares two syntax trees, ignoring positions and comments.
+func equal(a, b reflect.Value) bool {
+	if a.Kind() != b.Kind() {
+		return false
+	}
+	switch a.Type() {
+	case posType, objType, scopeType, cgroupType:
+		return true
+	}
+	switch a.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		if a.Kind() == reflect.Interface && a.Elem().Type() != b.Elem().Type() {
+			return false
+		}
+		return equal(a.Elem(), b.Elem())
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !equal(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equal(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a.Interface() == b.Interface()
+	}
+}
+
+// diff returns a line diff from a to b in the style of `diff -u`, without
+// hunk headers.
+func diff(a, b string) string {
+	x := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	y := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var buf strings.Builder
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			fmt.Fprintf(&buf, "\t  %s\n", x[i])
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&buf, "\t- %s\n", x[i])
+			i++
+		default:
+			fmt.Fprintf(&buf, "\t+ %s\n", y[j])
+			j++
+		}
+	}
+	return buf.String()
+}
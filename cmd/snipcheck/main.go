@@ -0,0 +1,272 @@
+// Command snipcheck type-checks the Go snippets embedded in the comments of
+// a literate Go file.
+//
+// Every ```go block inside a comment is checked. A snippet can be
+//
+//   - a complete file with a package clause,
+//   - a list of declarations, which gets wrapped into a package,
+//   - a list of statements, which gets wrapped into a function.
+//
+// Packages that a snippet uses without importing them are imported
+// automatically, either from the standard library or from a short list of
+// known third-party packages (see knownImports). For fragments, unused
+// variables and imports are not reported.
+//
+// Errors are reported with their positions in the literate file.
+//
+// Usage:
+//
+//	snipcheck [-v] generics.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// knownImports maps package names that snippets may use without an import
+// to their import paths. Other names are assumed to be standard library
+// packages.
+var knownImports = map[string]string{
+	"generic": "github.com/cheekybits/genny/generic",
+}
+
+// A snippet is a ```go block from a comment.
+type snippet struct {
+	line   int // line number of the first code line in the literate file
+	indent string
+	code   string
+}
+
+func main() {
+	verbose := flag.Bool("v", false, "report every snippet, not only the failing ones")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: snipcheck [-v] file.go...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	imp := importer.ForCompiler(token.NewFileSet(), "gc", lookupExport)
+	failed := false
+	for _, filename := range flag.Args() {
+		snippets, err := extract(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "snipcheck:", err)
+			os.Exit(1)
+		}
+		for _, s := range snippets {
+			errs := check(s, imp)
+			for _, e := range errs {
+				fmt.Printf("%s:%s\n", filename, e)
+			}
+			if len(errs) > 0 {
+				failed = true
+			} else if *verbose {
+				fmt.Printf("%s:%d: ok\n", filename, s.line)
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// lookupExport finds the export data of a package with `go list`, so that
+// snippets can import the dependencies of the current module, too.
+func lookupExport(path string) (io.ReadCloser, error) {
+	out, err := exec.Command("go", "list", "-export", "-f", "{{.Export}}", path).Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			return nil, fmt.Errorf("go list %s: %s", path, bytes.TrimSpace(ee.Stderr))
+		}
+		return nil, err
+	}
+	return os.Open(string(bytes.TrimSpace(out)))
+}
+
+var fence = regexp.MustCompile("^([ \t]*)```(.*)$")
+
+// extract returns the ```go blocks from the comments of a Go file.
+func extract(filename string) ([]snippet, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	var snippets []snippet
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			line := fset.Position(c.Pos()).Line
+			var cur *snippet
+			for i, l := range strings.Split(c.Text, "\n") {
+				m := fence.FindStringSubmatch(l)
+				switch {
+				case m != nil && cur == nil:
+					if lang := strings.TrimSpace(m[2]); strings.EqualFold(lang, "go") {
+						cur = &snippet{line: line + i + 1, indent: m[1]}
+					} else if lang != "" {
+						// Skip a block in another language up to its closing fence.
+						cur = &snippet{line: -1}
+					}
+				case m != nil && strings.TrimSpace(m[2]) == "":
+					if cur.line > 0 {
+						snippets = append(snippets, *cur)
+					}
+					cur = nil
+				case cur != nil:
+					cur.code += strings.TrimPrefix(l, cur.indent) + "\n"
+				}
+			}
+		}
+	}
+	return snippets, nil
+}
+
+// A checkError is an error at a position in the literate file.
+type checkError struct {
+	line, col int
+	msg       string
+}
+
+func (e checkError) String() string {
+	return fmt.Sprintf("%d:%d: %s", e.line, e.col, e.msg)
+}
+
+// check type-checks a snippet and returns its errors.
+func check(s snippet, imp types.Importer) []checkError {
+	wrappers := []struct {
+		prefix, suffix string
+		fragment       bool
+	}{
+		{"", "", false},
+		{"package snippet\n", "", true},
+		{"package snippet\nfunc _() {\n", "}\n", true},
+	}
+	var firstErr []checkError
+	for i, w := range wrappers {
+		src := w.prefix + s.code + w.suffix
+		offset := strings.Count(w.prefix, "\n")
+		errs, parsed := typeCheck(src, w.fragment, imp)
+		for j := range errs {
+			errs[j].line += s.line - 1 - offset
+			if errs[j].col > 0 {
+				errs[j].col += len(s.indent)
+			}
+		}
+		if parsed {
+			return errs
+		}
+		if i == 0 {
+			// If the snippet fits no wrapper, the errors of the
+			// unwrapped snippet are the most meaningful ones.
+			firstErr = errs
+		}
+	}
+	return firstErr
+}
+
+// typeCheck parses and type-checks src. It reports whether src could be
+// parsed at all; if not, the errors are syntax errors.
+func typeCheck(src string, fragment bool, imp types.Importer) ([]checkError, bool) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "snippet.go", src, 0)
+	if err != nil {
+		var errs []checkError
+		var list scanner.ErrorList
+		if errors.As(err, &list) {
+			for _, e := range list {
+				errs = append(errs, checkError{e.Pos.Line, e.Pos.Column, e.Msg})
+			}
+		} else {
+			errs = append(errs, checkError{1, 0, err.Error()})
+		}
+		return errs, false
+	}
+	addImports(f)
+
+	var errs []checkError
+	conf := types.Config{
+		Importer: imp,
+		Error: func(err error) {
+			te := err.(types.Error)
+			if fragment && (strings.Contains(te.Msg, "declared and not used") || strings.Contains(te.Msg, "imported and not used")) {
+				return
+			}
+			pos := fset.Position(te.Pos)
+			errs = append(errs, checkError{pos.Line, pos.Column, te.Msg})
+		},
+	}
+	conf.Check(f.Name.Name, fset, []*ast.File{f}, nil)
+	return errs, true
+}
+
+// addImports adds imports for all packages that f uses but does not import.
+// The added import specs have no position, so they do not shift any line.
+func addImports(f *ast.File) {
+	declared := map[string]bool{}
+	for _, is := range f.Imports {
+		name := strings.Trim(is.Path.Value, `"`)
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+		if is.Name != nil {
+			name = is.Name.Name
+		}
+		declared[name] = true
+	}
+	for _, obj := range f.Scope.Objects {
+		declared[obj.Name] = true
+	}
+
+	missing := map[string]bool{}
+	for _, id := range f.Unresolved {
+		if !declared[id.Name] && types.Universe.Lookup(id.Name) == nil {
+			missing[id.Name] = true
+		}
+	}
+	// Only identifiers that are used as the package part of a selector can
+	// be package names.
+	used := map[string]bool{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok && missing[x.Name] {
+				used[x.Name] = true
+			}
+		}
+		return true
+	})
+
+	names := make([]string, 0, len(used))
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path, ok := knownImports[name]
+		if !ok {
+			path = name
+		}
+		spec := &ast.ImportSpec{Path: &ast.BasicLit{Kind: token.STRING, Value: fmt.Sprintf("%q", path)}}
+		f.Imports = append(f.Imports, spec)
+		f.Decls = append([]ast.Decl{&ast.GenDecl{Tok: token.IMPORT, Specs: []ast.Spec{spec}}}, f.Decls...)
+	}
+}
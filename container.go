@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var (
+	// ErrEmpty is returned when reading from an empty container.
+	ErrEmpty = errors.New("container is empty")
+	// ErrWrongType is wrapped by the errors about elements or targets of
+	// the wrong type.
+	ErrWrongType = errors.New("wrong type")
+)
+
+// minContainerSize is the smallest size of the ring buffer of a Container.
+const minContainerSize = 8
+
+// TypedContainer is a Container that only accepts elements of one type.
+// Put, FromChan, UnmarshalJSON, and GobDecode refuse elements of other
+// types; all other methods are those of Container. Clear keeps the element
+// type.
+type TypedContainer struct {
+	Container
+	typ reflect.Type
+}
+
+// NewTypedContainer creates a container that only accepts elements of type t.
+// If t is an interface type, the container accepts all elements that
+// implement t, including nil. If t is nil, the first element that is put
+// into the container determines the type.
+func NewTypedContainer(t reflect.Type) *TypedContainer {
+	return &TypedContainer{typ: t}
+}
+
+// Type returns the element type of the container, or nil if the type is
+// determined by the first element, which has not been put yet.
+func (c *TypedContainer) Type() reflect.Type {
+	return c.typ
+}
+
+// Put adds an element to the container. If the element is not of the
+// container's type, Put returns an error wrapping ErrWrongType.
+func (c *TypedContainer) Put(elem interface{}) error {
+	if err := c.checkType(elem); err != nil {
+		return err
+	}
+	c.Container.Put(elem)
+	return nil
+}
+
+// checkType checks that elem may be put into the container.
+func (c *TypedContainer) checkType(elem interface{}) error {
+	if elem == nil {
+		if c.typ == nil || c.typ.Kind() != reflect.Interface {
+			return fmt.Errorf("Put: cannot put an untyped nil into a container of %v: %w", c.typ, ErrWrongType)
+		}
+		return nil
+	}
+	t := reflect.TypeOf(elem)
+	if c.typ == nil {
+		c.typ = t
+		return nil
+	}
+	if t != c.typ && (c.typ.Kind() != reflect.Interface || !t.Implements(c.typ)) {
+		return fmt.Errorf("Put: cannot put a %s into a container of %s: %w", t, c.typ, ErrWrongType)
+	}
+	return nil
+}
+
+// GetInto gets an element from the container and stores it in the variable
+// that ptr points to. It returns ErrEmpty if the container is empty, and an
+// error wrapping ErrWrongType if the element does not fit into the variable.
+// In this case, the element remains in the container.
+func (c *Container) GetInto(ptr interface{}) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("GetInto: expected a non-nil pointer, got %T: %w", ptr, ErrWrongType)
+	}
+	elem, ok := c.Peek()
+	if !ok {
+		return ErrEmpty
+	}
+	dst := v.Elem()
+	if elem == nil {
+		if !canBeNil(dst.Kind()) {
+			return fmt.Errorf("GetInto: cannot store nil into a %s: %w", dst.Type(), ErrWrongType)
+		}
+		dst.Set(reflect.Zero(dst.Type()))
+	} else {
+		ev := reflect.ValueOf(elem)
+		if !ev.Type().AssignableTo(dst.Type()) {
+			return fmt.Errorf("GetInto: cannot store a %s into a %s: %w", ev.Type(), dst.Type(), ErrWrongType)
+		}
+		dst.Set(ev)
+	}
+	c.TryGet()
+	return nil
+}
+
+// canBeNil reports whether values of kind k can be nil.
+func canBeNil(k reflect.Kind) bool {
+	switch k {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice, reflect.UnsafePointer:
+		return true
+	}
+	return false
+}
+
+// Len returns the number of elements in the container.
+func (c *Container) Len() int {
+	return c.n
+}
+
+// IsEmpty reports whether the container holds no elements.
+func (c *Container) IsEmpty() bool {
+	return c.n == 0
+}
+
+// TryGet gets an element from the container. Unlike Get, it does not panic
+// on an empty container but returns false instead.
+func (c *Container) TryGet() (interface{}, bool) {
+	if c.IsEmpty() {
+		return nil, false
+	}
+	elem := c.s[c.head]
+	c.s[c.head] = nil // do not keep the element alive through the ring buffer
+	c.head = (c.head + 1) % len(c.s)
+	c.n--
+	if c.n <= len(c.s)/4 {
+		c.resize(len(c.s) / 2)
+	}
+	return elem, true
+}
+
+// GetErr gets an element from the container, or returns ErrEmpty if there is none.
+func (c *Container) GetErr() (interface{}, error) {
+	elem, ok := c.TryGet()
+	if !ok {
+		return nil, ErrEmpty
+	}
+	return elem, nil
+}
+
+// Peek returns the element that the next Get would return, without removing it.
+func (c *Container) Peek() (interface{}, bool) {
+	if c.IsEmpty() {
+		return nil, false
+	}
+	return c.s[c.head], true
+}
+
+// Clear removes all elements from the container.
+func (c *Container) Clear() {
+	*c = Container{}
+}
+
+// resize moves the elements into a new ring buffer of the given size, which
+// must be at least c.n. Growing by doubling and shrinking by half when the
+// buffer is only a quarter full keeps Put and Get at amortized O(1), without
+// resizing back and forth at the boundary.
+func (c *Container) resize(size int) {
+	if size < minContainerSize {
+		size = minContainerSize
+	}
+	if size == len(c.s) {
+		return
+	}
+	s := make([]interface{}, size)
+	k := copy(s, c.s[c.head:min(c.head+c.n, len(c.s))])
+	copy(s[k:], c.s[:c.n-k])
+	c.s = s
+	c.head = 0
+}
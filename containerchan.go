@@ -0,0 +1,63 @@
+package main
+
+import "context"
+
+// ToChan returns a channel that a goroutine feeds with the elements of the
+// container, in the order of Get. The goroutine closes the channel and
+// exits when the container is empty or ctx is done, whatever comes first.
+// It only removes an element once the element has been received, so after
+// a cancellation, the container still holds the elements that nobody
+// received. The container must not be used by anyone else until the
+// channel is closed.
+func (c *Container) ToChan(ctx context.Context) <-chan interface{} {
+	ch := make(chan interface{})
+	go func() {
+		defer close(ch)
+		for {
+			elem, ok := c.Peek()
+			if !ok {
+				return
+			}
+			select {
+			case ch <- elem:
+				c.TryGet()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// FromChan puts the elements received from ch into the container until ch
+// is closed, and returns nil then. If ctx is done first, FromChan returns
+// ctx.Err(). It does not start any goroutines.
+func (c *Container) FromChan(ctx context.Context, ch <-chan interface{}) error {
+	return fromChan(ctx, ch, func(elem interface{}) error {
+		c.Put(elem)
+		return nil
+	})
+}
+
+// FromChan is like Container.FromChan, but if an element does not fit into
+// the container, it stops and returns the error of Put; the element is lost.
+func (c *TypedContainer) FromChan(ctx context.Context, ch <-chan interface{}) error {
+	return fromChan(ctx, ch, c.Put)
+}
+
+// fromChan calls put with the elements received from ch.
+func fromChan(ctx context.Context, ch <-chan interface{}, put func(elem interface{}) error) error {
+	for {
+		select {
+		case elem, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if err := put(elem); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// checkGoroutines fails the test if the number of goroutines does not
+// return to base soon.
+func checkGoroutines(t *testing.T, base int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > base {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines leaked", runtime.NumGoroutine()-base)
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestContainerToChanDrains(t *testing.T) {
+	base := runtime.NumGoroutine()
+	c := &Container{}
+	for i := 0; i < 10; i++ {
+		c.Put(i)
+	}
+	want := 0
+	for v := range c.ToChan(context.Background()) {
+		if v != want {
+			t.Fatalf("received %v, want %d", v, want)
+		}
+		want++
+	}
+	if want != 10 || c.Len() != 0 {
+		t.Errorf("received %d elements, %d left, want 10 and 0", want, c.Len())
+	}
+	checkGoroutines(t, base)
+}
+
+func TestContainerToChanCancel(t *testing.T) {
+	base := runtime.NumGoroutine()
+	c := &Container{}
+	for i := 0; i < 10; i++ {
+		c.Put(i)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := c.ToChan(ctx)
+	<-ch
+	<-ch
+	cancel()
+	checkGoroutines(t, base)
+	if _, ok := <-ch; ok {
+		t.Error("channel still open after cancel")
+	}
+	if c.Len() != 8 {
+		t.Errorf("Len = %d after cancel, want 8", c.Len())
+	}
+	if v := c.Get(); v != 2 {
+		t.Errorf("Get = %v after cancel, want 2", v)
+	}
+}
+
+func TestContainerFromChanCancel(t *testing.T) {
+	base := runtime.NumGoroutine()
+	c := &Container{}
+	ch := make(chan interface{})
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() { errc <- c.FromChan(ctx, ch) }()
+	ch <- 1
+	ch <- "two"
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("FromChan = %v, want %v", err, context.Canceled)
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len = %d, want 2", c.Len())
+	}
+	checkGoroutines(t, base)
+}
+
+func TestTypedContainerFromChan(t *testing.T) {
+	base := runtime.NumGoroutine()
+	c := NewTypedContainer(reflect.TypeOf(0))
+	ch := make(chan interface{}, 3)
+	ch <- 1
+	ch <- "two"
+	ch <- 3
+	close(ch)
+	if err := c.FromChan(context.Background(), ch); !errors.Is(err, ErrWrongType) {
+		t.Errorf("FromChan = %v, want ErrWrongType", err)
+	}
+	if c.Len() != 1 {
+		t.Errorf("Len = %d, want 1", c.Len())
+	}
+	checkGoroutines(t, base)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// A Container can be encoded as JSON or gob. As the elements can be of any
+// type, the encoding tags each element with the name of its type, and the
+// decoder looks up the type by that name in a registry. This way, an int
+// comes back as an int rather than as a float64, and an Order as an Order
+// rather than as a map.
+//
+// The encoding only contains the elements. A TypedContainer keeps its
+// element type when it decodes, and refuses elements of other types.
+
+// ErrUnregistered is wrapped by the errors about elements whose type has
+// not been registered with RegisterType.
+var ErrUnregistered = errors.New("unregistered type")
+
+var registry = struct {
+	sync.RWMutex
+	types map[string]reflect.Type
+	names map[reflect.Type]string
+}{
+	types: map[string]reflect.Type{},
+	names: map[reflect.Type]string{},
+}
+
+func init() {
+	// The predeclared types are registered under their Go names.
+	for _, v := range []interface{}{
+		false, "",
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), float64(0),
+	} {
+		if err := RegisterType(reflect.TypeOf(v).String(), v); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterType registers the type of sample under name, so that containers
+// can encode and decode elements of this type. The type must also be
+// encodable with encoding/json or encoding/gob, depending on which
+// encoding is used. Registering a type again under the same name does
+// nothing; a name or a type can only be registered once, though.
+//
+// The predeclared boolean, numeric, and string types are registered under
+// their Go names, like "int" or "float64".
+func RegisterType(name string, sample interface{}) error {
+	if sample == nil {
+		return fmt.Errorf("RegisterType: cannot register the type of nil: %w", ErrWrongType)
+	}
+	if name == "" {
+		return fmt.Errorf("RegisterType: empty name for %T", sample)
+	}
+	t := reflect.TypeOf(sample)
+	registry.Lock()
+	defer registry.Unlock()
+	if other, ok := registry.types[name]; ok && other != t {
+		return fmt.Errorf("RegisterType: name %q is already registered for %s", name, other)
+	}
+	if other, ok := registry.names[t]; ok && other != name {
+		return fmt.Errorf("RegisterType: %s is already registered as %q", t, other)
+	}
+	registry.types[name] = t
+	registry.names[t] = name
+	return nil
+}
+
+// typeName returns the name under which the type of elem is registered.
+func typeName(op string, elem interface{}) (string, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+	name, ok := registry.names[reflect.TypeOf(elem)]
+	if !ok {
+		return "", fmt.Errorf("%s: %T: %w", op, elem, ErrUnregistered)
+	}
+	return name, nil
+}
+
+// typeByName returns the type registered under name.
+func typeByName(op, name string) (reflect.Type, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+	t, ok := registry.types[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %q: %w", op, name, ErrUnregistered)
+	}
+	return t, nil
+}
+
+// elems returns the elements of the container in the order in which Get
+// would return them.
+func (c Container) elems() []interface{} {
+	elems := make([]interface{}, c.n)
+	for i := range elems {
+		elems[i] = c.s[(c.head+i)%len(c.s)]
+	}
+	return elems
+}
+
+// refill replaces the elements of the container with elems.
+func (c *Container) refill(elems []interface{}) {
+	*c = Container{}
+	for _, elem := range elems {
+		c.Put(elem)
+	}
+}
+
+// refill replaces the elements of the container with elems. If an element
+// does not fit into the container, the container remains unchanged.
+func (c *TypedContainer) refill(op string, elems []interface{}) error {
+	tmp := TypedContainer{typ: c.typ}
+	for _, elem := range elems {
+		if err := tmp.Put(elem); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	*c = tmp
+	return nil
+}
+
+// A jsonElem is the JSON encoding of an element other than nil, which is
+// encoded as null.
+type jsonElem struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// MarshalJSON encodes the elements as a JSON array of objects like
+// {"type":"order","value":{...}}, where "order" is the name under which
+// the element's type is registered. A nil element is encoded as null.
+func (c Container) MarshalJSON() ([]byte, error) {
+	out := make([]*jsonElem, c.n)
+	for i, elem := range c.elems() {
+		if elem == nil {
+			continue
+		}
+		name, err := typeName("MarshalJSON", elem)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(elem)
+		if err != nil {
+			return nil, fmt.Errorf("MarshalJSON: %w", err)
+		}
+		out[i] = &jsonElem{name, v}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON replaces the elements of the container with the elements
+// encoded by MarshalJSON.
+func (c *Container) UnmarshalJSON(data []byte) error {
+	elems, err := unmarshalJSON(data)
+	if err != nil {
+		return err
+	}
+	c.refill(elems)
+	return nil
+}
+
+// UnmarshalJSON is like Container.UnmarshalJSON but refuses elements of the
+// wrong type.
+func (c *TypedContainer) UnmarshalJSON(data []byte) error {
+	elems, err := unmarshalJSON(data)
+	if err != nil {
+		return err
+	}
+	return c.refill("UnmarshalJSON", elems)
+}
+
+// unmarshalJSON decodes the elements encoded by MarshalJSON.
+func unmarshalJSON(data []byte) ([]interface{}, error) {
+	var in []*jsonElem
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, fmt.Errorf("UnmarshalJSON: %w", err)
+	}
+	elems := make([]interface{}, len(in))
+	for i, e := range in {
+		if e == nil {
+			continue
+		}
+		t, err := typeByName("UnmarshalJSON", e.Type)
+		if err != nil {
+			return nil, err
+		}
+		v := reflect.New(t)
+		if err := json.Unmarshal(e.Value, v.Interface()); err != nil {
+			return nil, fmt.Errorf("UnmarshalJSON: element %d: %w", i, err)
+		}
+		elems[i] = v.Elem().Interface()
+	}
+	return elems, nil
+}
+
+// GobEncode encodes the elements as a gob stream that consists of the
+// number of elements, followed by the registered type name and the value
+// of each element. A nil element has the type name "" and no value.
+func (c Container) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(c.n); err != nil {
+		return nil, fmt.Errorf("GobEncode: %w", err)
+	}
+	for _, elem := range c.elems() {
+		name := ""
+		if elem != nil {
+			var err error
+			if name, err = typeName("GobEncode", elem); err != nil {
+				return nil, err
+			}
+		}
+		if err := enc.Encode(name); err != nil {
+			return nil, fmt.Errorf("GobEncode: %w", err)
+		}
+		if elem == nil {
+			continue
+		}
+		if err := enc.EncodeValue(reflect.ValueOf(elem)); err != nil {
+			return nil, fmt.Errorf("GobEncode: %s: %w", name, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode replaces the elements of the container with the elements
+// encoded by GobEncode.
+func (c *Container) GobDecode(data []byte) error {
+	elems, err := gobDecode(data)
+	if err != nil {
+		return err
+	}
+	c.refill(elems)
+	return nil
+}
+
+// GobDecode is like Container.GobDecode but refuses elements of the wrong
+// type.
+func (c *TypedContainer) GobDecode(data []byte) error {
+	elems, err := gobDecode(data)
+	if err != nil {
+		return err
+	}
+	return c.refill("GobDecode", elems)
+}
+
+// gobDecode decodes the elements encoded by GobEncode.
+func gobDecode(data []byte) ([]interface{}, error) {
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	var n int
+	if err := dec.Decode(&n); err != nil {
+		return nil, fmt.Errorf("GobDecode: %w", err)
+	}
+	if n < 0 || n > len(data) {
+		// Every element takes at least one byte.
+		return nil, fmt.Errorf("GobDecode: invalid number of elements %d", n)
+	}
+	elems := make([]interface{}, n)
+	for i := range elems {
+		var name string
+		if err := dec.Decode(&name); err != nil {
+			return nil, fmt.Errorf("GobDecode: %w", err)
+		}
+		if name == "" {
+			continue
+		}
+		t, err := typeByName("GobDecode", name)
+		if err != nil {
+			return nil, err
+		}
+		v := reflect.New(t)
+		if err := dec.DecodeValue(v); err != nil {
+			return nil, fmt.Errorf("GobDecode: element %d: %w", i, err)
+		}
+		elems[i] = v.Elem().Interface()
+	}
+	return elems, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type order struct {
+	ID    int
+	Items []string
+}
+
+type unregistered struct{ X int }
+
+func init() {
+	for name, sample := range map[string]interface{}{"order": order{}, "*order": &order{}} {
+		if err := RegisterType(name, sample); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// A codec encodes and decodes containers in one of the two encodings.
+type codec struct {
+	name string
+	enc  func(c *Container) ([]byte, error)
+	dec  func(data []byte, c interface{}) error
+}
+
+var codecs = []codec{
+	{
+		"JSON",
+		func(c *Container) ([]byte, error) { return json.Marshal(c) },
+		func(data []byte, c interface{}) error { return json.Unmarshal(data, c) },
+	},
+	{
+		"gob",
+		func(c *Container) ([]byte, error) { return c.GobEncode() },
+		func(data []byte, c interface{}) error { return c.(gob.GobDecoder).GobDecode(data) },
+	},
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	elems := []interface{}{
+		7, "seven", 7.5, uint8(7), int64(-7), true, nil,
+		order{ID: 1, Items: []string{"a", "b"}},
+		&order{ID: 2},
+	}
+	for _, cd := range codecs {
+		t.Run(cd.name, func(t *testing.T) {
+			c := &Container{}
+			for _, e := range elems {
+				c.Put(e)
+			}
+			data, err := cd.enc(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := &Container{}
+			got.Put("old element")
+			if err := cd.dec(data, got); err != nil {
+				t.Fatal(err)
+			}
+			// DeepEqual also compares the dynamic types, so an int must
+			// come back as an int, not as a float64.
+			if !reflect.DeepEqual(got.elems(), elems) {
+				t.Errorf("decoded %#v, want %#v", got.elems(), elems)
+			}
+		})
+	}
+}
+
+func TestCodecUnregistered(t *testing.T) {
+	c := &Container{}
+	c.Put(1)
+	c.Put(unregistered{1})
+	for _, cd := range codecs {
+		if _, err := cd.enc(c); !errors.Is(err, ErrUnregistered) {
+			t.Errorf("%s: encoding %T = %v, want ErrUnregistered", cd.name, unregistered{}, err)
+		}
+	}
+
+	// Streams with an unknown type name, as an older or newer program
+	// might write them.
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	for _, v := range []interface{}{1, "unregistered", unregistered{1}} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	streams := map[string][]byte{
+		"JSON": []byte(`[{"type":"int","value":1},{"type":"unregistered","value":{"X":1}}]`),
+		"gob":  buf.Bytes(),
+	}
+	for _, cd := range codecs {
+		got := &Container{}
+		got.Put("old element")
+		if err := cd.dec(streams[cd.name], got); !errors.Is(err, ErrUnregistered) {
+			t.Errorf("%s: decoding an unregistered type = %v, want ErrUnregistered", cd.name, err)
+		}
+		if !reflect.DeepEqual(got.elems(), []interface{}{"old element"}) {
+			t.Errorf("%s: container holds %v after a failed decode", cd.name, got.elems())
+		}
+	}
+}
+
+func TestCodecTypedContainer(t *testing.T) {
+	ints := &Container{}
+	ints.Put(1)
+	ints.Put(2)
+	mixed := &Container{}
+	mixed.Put(3)
+	mixed.Put("four")
+	withNil := &Container{}
+	withNil.Put(nil)
+	for _, cd := range codecs {
+		t.Run(cd.name, func(t *testing.T) {
+			c := NewTypedContainer(reflect.TypeOf(0))
+			c.Put(9)
+			data, err := cd.enc(ints)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cd.dec(data, c); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c.elems(), []interface{}{1, 2}) {
+				t.Errorf("decoded %v, want [1 2]", c.elems())
+			}
+			for _, src := range []*Container{mixed, withNil} {
+				data, err := cd.enc(src)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := cd.dec(data, c); !errors.Is(err, ErrWrongType) {
+					t.Errorf("decoding %v into a container of int = %v, want ErrWrongType", src.elems(), err)
+				}
+				if !reflect.DeepEqual(c.elems(), []interface{}{1, 2}) {
+					t.Errorf("container holds %v after a failed decode, want [1 2]", c.elems())
+				}
+			}
+			if c.Type() != reflect.TypeOf(0) {
+				t.Errorf("Type = %v after decoding, want int", c.Type())
+			}
+		})
+	}
+}
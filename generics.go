@@ -1,4 +1,5 @@
-//go:generate genny -in=capsule/capsule.go -out=capsule/uint32capsule.go gen "Item=uint32"
+//go:generate go run ./cmd/capgen -in=capsule/capsule.go -out=capsule/uint32capsule.go "Item=uint32"
+//go:generate go run ./cmd/inlinecheck -w generics.go capsule/capsule.go capsule/uint32capsule.go
 
 package main
 
@@ -211,22 +212,32 @@ Generic container types usually do not need to care much about the actual type o
 
 It is quite easy to build a container type based on `interface{}`. We only need a way to recover the actual data type when reading elements from the container. For that purpose, Go has type assertions. Here is an example that implements a generic container object.
 
-To keep the code short and concise, the container object is kept super-simple. It features only two methods, Put and Get.
+To keep the code short and concise, the article only shows the two basic methods of the container, Put and Get. The container keeps its elements in a ring buffer rather than in a plain slice, so that it releases memory when it shrinks and does not keep retrieved elements alive. The buffer management and further methods like `TryGet`, which never panics, live in `container.go`. Get itself panics with `ErrEmpty` if the container is empty.
 
 */
 
-// `Container` is a generic container, accepting anything.
-type Container []interface{}
+// `Container` is a generic container, accepting anything. The elements are stored in a ring buffer that grows and shrinks as needed. (See `container.go` for the details.)
+type Container struct {
+	s    []interface{}
+	head int // index of the next element to Get
+	n    int // number of elements
+}
 
 // Put adds an element to the container.
 func (c *Container) Put(elem interface{}) {
-	*c = append(*c, elem)
+	if c.n == len(c.s) {
+		c.resize(2 * c.n)
+	}
+	c.s[(c.head+c.n)%len(c.s)] = elem
+	c.n++
 }
 
 // Get gets an element from the container.
 func (c *Container) Get() interface{} {
-	elem := (*c)[0]
-	*c = (*c)[1:]
+	elem, ok := c.TryGet()
+	if !ok {
+		panic(ErrEmpty)
+	}
 	return elem
 }
 
@@ -246,6 +257,8 @@ func assertExample() {
 
 This looks so straightforward that we might easily forget the downsides of this technique. We give up compile-time type checking here, exposing the application to increased risk of type-related failure at runtime. Also, conversions to and from interfaces come with a cost. And finally, all 'magic' happens outside our Container type, at the caller's level. Usually you would rather want a technique that hides the type conversion mechanisms from the caller.
 
+(A middle ground is a container that checks the element type at runtime: `NewTypedContainer()` in `container.go` creates a `TypedContainer` whose `Put` rejects elements of the wrong type, and `GetInto` does the type assertion on behalf of the caller. This way, type errors at least surface when an element is inserted, rather than at some distant place where it is read. But this check is still a runtime check, and it uses reflection, which is the topic of the next section.)
+
 
 ### 5. Use reflection
 
@@ -269,32 +282,53 @@ func NewCabinet(t reflect.Type) *Cabinet {
 	}
 }
 
-// Set sets the element at index i to the passed-in value.
-func (c *Cabinet) Put(val interface{}) {
-	// The passed-in `val` must match the type of the elements of slice `s`. Otherwise, Put panics, as this is a programmer error.
-	if reflect.ValueOf(val).Type() != c.s.Type().Elem() {
-		panic(fmt.Sprintf("Put: cannot put a %T into a slice of %s", val, c.s.Type().Elem()))
+// Put appends the passed-in value to the cabinet.
+func (c *Cabinet) Put(val interface{}) error {
+	// The passed-in `val` must be assignable to the elements of slice `s`. (A plain type comparison is not enough; for example, a cabinet of `io.Reader` must accept a `*os.File`.) `value()`, which lives in cabinet.go, does this check and returns `val` as a `reflect.Value`. It also deals with `nil`.
+	v, err := c.value(val)
+	if err != nil {
+		return fmt.Errorf("Put: %w", err)
 	}
-	// `AppendSlice` is a replacement for the builtin `append` function, which fails on a `reflect.Value` even if the actual value's type is a slice.
-	c.s = reflect.Append(c.s, reflect.ValueOf(val))
+	// `Append` is a replacement for the builtin `append` function, which fails on a `reflect.Value` even if the actual value's type is a slice.
+	c.s = reflect.Append(c.s, v)
+	return nil
 }
 
 // Get gets the element at index `i`. There is no way (or so it seems) to have a function return a `reflect.Value` type that turns into the actual type of the returned data. Hence the Get function has got a parameter of type `interface{}` instead, and the actual argument must be a pointer to the receiving variable. See `reflectExample()`.
-func (c *Cabinet) Get(retref interface{}) {
-	// `Index(i)` replaces the index operator `[i]` as `s` is only a reflect.Value (even though it effectively contains a slice).
-	retref = c.s.Index(0)
+func (c *Cabinet) Get(retref interface{}) error {
+	// `retref` must be a non-nil pointer, and the cabinet's element type must fit into the variable it points to.
+	ref := reflect.ValueOf(retref)
+	if ref.Kind() != reflect.Ptr {
+		return fmt.Errorf("Get: expected a pointer, got %T: %w", retref, ErrWrongType)
+	}
+	if ref.IsNil() {
+		return fmt.Errorf("Get: cannot store into a nil %T: %w", retref, ErrWrongType)
+	}
+	if !c.s.Type().Elem().AssignableTo(ref.Elem().Type()) {
+		return fmt.Errorf("Get: cannot store a %s into a %s: %w", c.s.Type().Elem(), ref.Elem().Type(), ErrWrongType)
+	}
+	if c.Len() == 0 {
+		return ErrEmpty
+	}
+	// `Index(i)` replaces the index operator `[i]` as `s` is only a reflect.Value (even though it effectively contains a slice). `Elem().Set()` replaces the assignment `*retref = ...`, which is not possible on an `interface{}`.
+	ref.Elem().Set(c.s.Index(0))
 	c.s = c.s.Slice(1, c.s.Len())
+	return nil
 }
 
 func reflectExample() {
 	f := 3.14152
 	g := 0.0
 	c := NewCabinet(reflect.TypeOf(f))
-	// Try c.Put(0, "blabla") to see the type check panicking
-	c.Put(f)
-	// The syntax `g = c.Get(0)` is not possible, see the comment on `Get()`.
-	fmt.Println(c.s.Index(0))
-	c.Get(&g)
+	// Try c.Put("blabla") to see the type check failing
+	if err := c.Put(f); err != nil {
+		fmt.Println("Unable to put a float64 into c:", err)
+	}
+	// The syntax `g = c.Get(0)` is not possible, see the comment on `Get()`. `Slice()` (in cabinet.go) gets us the contents as a `[]float64`, though.
+	fmt.Println(c.Slice().([]float64))
+	if err := c.Get(&g); err != nil {
+		fmt.Println("Unable to read a float64 from c:", err)
+	}
 	fmt.Printf("reflectExample: %f (%T)\n", g, g)
 }
 
@@ -335,23 +369,54 @@ import "github.com/cheekybits/genny/generic"
 type Item generic.Type
 
 type ItemCapsule struct {
-	s []Item
+	s    []Item
+	head int // index of the next element to Get
+	n    int // number of elements
 }
 
 func NewItemCapsule() *ItemCapsule {
-	return &ItemCapsule{s: []Item{}}
+	return &ItemCapsule{}
 }
 
 func (c *ItemCapsule) Put(val Item) {
-	c.s = append(c.s, val)
+	if c.n == len(c.s) {
+		c.resize(2 * c.n)
+	}
+	c.s[(c.head+c.n)%len(c.s)] = val
+	c.n++
 }
 
 func (c *ItemCapsule) Get() Item {
-	r := c.s[0]
-	c.s = c.s[1:]
+	if c.n == 0 {
+		panic("capsule: Get from empty capsule")
+	}
+	var zero Item
+	r := c.s[c.head]
+	c.s[c.head] = zero // do not keep the element alive
+	c.head = (c.head + 1) % len(c.s)
+	c.n--
+	if c.n <= len(c.s)/4 {
+		c.resize(len(c.s) / 2)
+	}
 	return r
 }
+
+func (c *ItemCapsule) resize(size int) {
+	if size < 8 {
+		size = 8
+	}
+	if size == len(c.s) {
+		return
+	}
+	s := make([]Item, size)
+	k := copy(s, c.s[c.head:min(c.head+c.n, len(c.s))])
+	copy(s[k:], c.s[:c.n-k])
+	c.s = s
+	c.head = 0
+}
 ```
+The capsule keeps its elements in a ring buffer. Unlike the simpler `c.s = c.s[1:]` approach, this releases memory when the capsule shrinks, and no element stays reachable after `Get` has returned it. This makes the capsule suitable for long-running queues.
+
 (When you `go get` the code of this article, this template code is in `capsule/capsule.go`.)
 
 In the main file, `generics.go`, we want to have a Capsule containing uint32 elements. So we place a `go:generate` directive at the top of the file:
@@ -365,23 +430,52 @@ After running `go generate generics.go`, we can find the file `uint32capsule.go`
 
 // Generated code
 type Uint32Capsule struct {
-	s []uint32
+	s    []uint32
+	head int // index of the next element to Get
+	n    int // number of elements
 }
 
 func NewUint32Capsule() *Uint32Capsule {
-	return &Uint32Capsule{s: []uint32{}}
+	return &Uint32Capsule{}
 }
 
 func (c *Uint32Capsule) Put(val uint32) {
-	c.s = append(c.s, val)
+	if c.n == len(c.s) {
+		c.resize(2 * c.n)
+	}
+	c.s[(c.head+c.n)%len(c.s)] = val
+	c.n++
 }
 
 func (c *Uint32Capsule) Get() uint32 {
-	r := c.s[0]
-	c.s = c.s[1:]
+	if c.n == 0 {
+		panic("capsule: Get from empty capsule")
+	}
+	var zero uint32
+	r := c.s[c.head]
+	c.s[c.head] = zero // do not keep the element alive
+	c.head = (c.head + 1) % len(c.s)
+	c.n--
+	if c.n <= len(c.s)/4 {
+		c.resize(len(c.s) / 2)
+	}
 	return r
 }
 
+func (c *Uint32Capsule) resize(size int) {
+	if size < 8 {
+		size = 8
+	}
+	if size == len(c.s) {
+		return
+	}
+	s := make([]uint32, size)
+	k := copy(s, c.s[c.head:min(c.head+c.n, len(c.s))])
+	copy(s[k:], c.s[:c.n-k])
+	c.s = s
+	c.head = 0
+}
+
 // Now we can write the calling function as if the Uint32Capsule was handcoded. Everything is just plain, clear, idiomatic Go.
 func generateExample() {
 	var u uint32 = 42
@@ -412,6 +506,16 @@ Get the complete test code from GitHub:
 
 You don't need to get `genny` nor run `go generate` if you just want to run the examples in `generics.go`. The code generated by `genny` is included in generics.go.
 
+The `go:generate` directive at the top of generics.go does not call genny but `cmd/capgen`, a small template instantiator included in this repository. It understands genny's `generic.Type` placeholders, so the template stays the same, and you do not need to install any extra tool.
+
+Curious about the runtime overhead of each technique? `benchmark_test.go` contains a set of benchmarks, and `cmd/benchreport` turns their results into comparison tables:
+
+		go test -run '^$' -bench . | go run ./cmd/benchreport
+
+(Be patient, this takes a few minutes. Use a regular expression like `-bench Techniques/burst/uint32` to run only some of them.)
+
+Since Go 1.18, Go has type parameters. For comparison, `capsule/typeparam.go` contains `Capsule[T]`, a type-parameterized version of the capsule that needs no `go generate` step at all.
+
 
 ## Summary
 
@@ -1,5 +1,5 @@
 module github.com/appliedgo/generics
 
-go 1.13
+go 1.21
 
 require github.com/cheekybits/genny v1.0.0